@@ -9,17 +9,34 @@ import (
 	"go.uber.org/zap"
 )
 
+type loginRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password" log:"mask"`
+}
+
 func main() {
 	l, _ := zap.NewProduction()
 
 	r := chi.NewRouter()
-	r.Use(httplog.ZapRequestLogger(l))
+	r.Use(httplog.ZapRequestLogger(l, httplog.WithMaskedBodyType("/login", loginRequest{})))
 	r.Use(middleware.Recoverer)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// authenticate here, then record who it was and how long it took
+			httplog.LogEntrySetAuth(r.Context(), "demo", true)
+			httplog.LogEntryMarkPhase(r.Context(), "auth")
+			next.ServeHTTP(w, r)
+		})
+	})
 
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("hello world"))
 	})
 
+	r.Post("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome"))
+	})
+
 	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
 		panic("oh no")
 	})
@@ -31,6 +48,15 @@ func main() {
 		w.Write([]byte("info here"))
 	})
 
+	r.Post("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		joblog := httplog.DetachedLogEntry(r.Context())
+		go func() {
+			// keeps logging with the request's fields after the response is sent
+			joblog.Info("job done")
+		}()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
 	r.Get("/warn", func(w http.ResponseWriter, r *http.Request) {
 		oplog := httplog.LogEntry(r.Context())
 		oplog.Warn("warn here")
@@ -38,6 +64,14 @@ func main() {
 		w.Write([]byte("warn here"))
 	})
 
+	r.Get("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// logged as the `error` object of the completion log, at Error level
+		httplog.LogEntrySetErrorDetails(r.Context(), "ORDER_NOT_FOUND", "order does not exist", map[string]interface{}{
+			"orderId": chi.URLParam(r, "id"),
+		})
+		w.WriteHeader(http.StatusNotFound)
+	})
+
 	r.Get("/err", func(w http.ResponseWriter, r *http.Request) {
 		oplog := httplog.LogEntry(r.Context())
 		oplog.Error("err here")
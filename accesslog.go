@@ -0,0 +1,86 @@
+package httplog
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// combinedLogFormat formats the request as a line of the Apache Combined Log Format:
+// %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"
+// requestURI is the request target as logged. The user of Basic authentication is only written when
+// logUser is set, and the quoted values are escaped as Apache does, so that they cannot break the line.
+func combinedLogFormat(r *http.Request, requestURI string, start time.Time, status, bytes int, logUser bool) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" && logUser {
+		user = escapeLogItem(u)
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.Itoa(bytes)
+	}
+
+	var b strings.Builder
+	b.WriteString(orDash(host))
+	b.WriteString(" - ")
+	b.WriteString(user)
+	b.WriteString(" [")
+	b.WriteString(start.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString("] \"")
+	b.WriteString(escapeLogItem(r.Method + " " + requestURI + " " + r.Proto))
+	b.WriteString("\" ")
+	b.WriteString(strconv.Itoa(status))
+	b.WriteString(" ")
+	b.WriteString(size)
+	b.WriteString(" \"")
+	b.WriteString(orDash(escapeLogItem(r.Referer())))
+	b.WriteString("\" \"")
+	b.WriteString(orDash(escapeLogItem(r.UserAgent())))
+	b.WriteString("\"")
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeLogItem escapes s like Apache's ap_escape_logitem: quotes and backslashes are backslash escaped,
+// and control and non-ASCII bytes are written as \n, \t and the like, or \xhh.
+func escapeLogItem(s string) string {
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\b':
+			b.WriteString(`\b`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\v':
+			b.WriteString(`\v`)
+		case c < 0x20 || c >= 0x7f:
+			b.WriteString(`\x`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
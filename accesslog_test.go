@@ -0,0 +1,73 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCombinedLogFormat(t *testing.T) {
+	start := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		target    string
+		referer   string
+		userAgent string
+		logUser   bool
+		want      string
+	}{
+		{"plain", "/items?q=1", "https://example.com/", "curl/8.0", false,
+			`192.0.2.1 - - [01/Mar/2024:12:30:00 +0000] "GET /items?q=1 HTTP/1.1" 200 2 "https://example.com/" "curl/8.0"`},
+		{"empty headers", "/", "", "", false,
+			`192.0.2.1 - - [01/Mar/2024:12:30:00 +0000] "GET / HTTP/1.1" 200 2 "-" "-"`},
+		{"quote and backslash", "/", `a"b`, `x\" 200 0 "-" "forged`, false,
+			`192.0.2.1 - - [01/Mar/2024:12:30:00 +0000] "GET / HTTP/1.1" 200 2 "a\"b" "x\\\" 200 0 \"-\" \"forged"`},
+		{"control characters", "/", "", "a\nb\tc\x01d\x7f", false,
+			`192.0.2.1 - - [01/Mar/2024:12:30:00 +0000] "GET / HTTP/1.1" 200 2 "-" "a\nb\tc\x01d\x7f"`},
+		{"non-ASCII", "/", "", "é", false,
+			`192.0.2.1 - - [01/Mar/2024:12:30:00 +0000] "GET / HTTP/1.1" 200 2 "-" "\xc3\xa9"`},
+		{"user", "/", "", "", true,
+			`192.0.2.1 - alice [01/Mar/2024:12:30:00 +0000] "GET / HTTP/1.1" 200 2 "-" "-"`},
+		{"user not logged", "/", "", "", false,
+			`192.0.2.1 - - [01/Mar/2024:12:30:00 +0000] "GET / HTTP/1.1" 200 2 "-" "-"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Referer", tt.referer)
+			r.Header.Set("User-Agent", tt.userAgent)
+			r.SetBasicAuth("alice", "secret")
+			if got := combinedLogFormat(r, tt.target, start, http.StatusOK, 2, tt.logUser); got != tt.want {
+				t.Errorf("combinedLogFormat =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinedLogUser(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		user string
+	}{
+		{"masked", nil, "-"},
+		{"scheme kept", []Option{WithAuthorizationScheme(true)}, "-"},
+		{"masking off", []Option{WithMasking(false)}, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.SetBasicAuth("alice", "secret")
+			serve(logger, writeBody(http.StatusOK, ""), r, append([]Option{WithCombinedLog(true)}, tt.opts...)...)
+			want := "192.0.2.1 - " + tt.user + " ["
+			if got, _ := loggedFields(t, logs, "Request complete")["accessLog"].(string); !strings.HasPrefix(got, want) {
+				t.Errorf("accessLog = %q, want it to start with %q", got, want)
+			}
+		})
+	}
+}
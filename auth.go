@@ -0,0 +1,21 @@
+package httplog
+
+import "context"
+
+// authLog is the outcome of authentication recorded with LogEntrySetAuth.
+type authLog struct {
+	subject       string
+	authenticated bool
+}
+
+// LogEntrySetAuth records the outcome of authentication, e.g. from an auth middleware, so every service logs it
+// the same way: the completion log then has `authSubject`, the authenticated principal, and `authenticated`.
+// The subject is omitted when empty, masked with WithMaskedAuthSubject, and can be dropped with WithFieldFilter.
+// The last call wins.
+func LogEntrySetAuth(ctx context.Context, subject string, authenticated bool) {
+	if entry, ok := entryFromContext(ctx); ok {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.auth = &authLog{subject: subject, authenticated: authenticated}
+	}
+}
@@ -0,0 +1,62 @@
+package httplog
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type baggageLog struct {
+	keys   []string
+	values map[string]string
+}
+
+// parseBaggage picks the entries named in keys out of the W3C Baggage headers of the request.
+// It returns nil when none of them is present.
+func parseBaggage(header http.Header, keys []string) *baggageLog {
+	values := header.Values("Baggage")
+	if len(values) == 0 || len(keys) == 0 {
+		return nil
+	}
+	wanted := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		wanted[k] = struct{}{}
+	}
+	b := &baggageLog{values: make(map[string]string)}
+	for _, v := range values {
+		for _, member := range strings.Split(v, ",") {
+			// Properties after ";" are metadata of the member and are not logged.
+			kv, _, _ := strings.Cut(member, ";")
+			k, val, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			k = strings.TrimSpace(k)
+			if _, ok := wanted[k]; !ok {
+				continue
+			}
+			val = strings.TrimSpace(val)
+			if unescaped, err := url.PathUnescape(val); err == nil {
+				val = unescaped
+			}
+			if _, seen := b.values[k]; !seen {
+				b.keys = append(b.keys, k)
+			}
+			b.values[k] = val
+		}
+	}
+	if len(b.keys) == 0 {
+		return nil
+	}
+	return b
+}
+
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (b *baggageLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, k := range b.keys {
+		enc.AddString(k, b.values[k])
+	}
+	return nil
+}
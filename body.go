@@ -0,0 +1,344 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// limitedBuffer keeps at most limit bytes written to it and silently drops the rest.
+// A limit of zero or less keeps everything.
+// It never returns an error so it is safe to use as the Tee of a response writer.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// maxPooledBufferSize is the capacity over which a limitedBuffer is left to the garbage collector
+// rather than pooled, so that one large body does not pin its memory for good.
+const maxPooledBufferSize = 256 << 10
+
+var limitedBufferPool = sync.Pool{
+	New: func() interface{} { return new(limitedBuffer) },
+}
+
+// getLimitedBuffer returns an empty limitedBuffer from the pool. Pass it to putLimitedBuffer once
+// the log is written, and copy out anything handed to zap, which may keep fields past the write.
+func getLimitedBuffer(limit int) *limitedBuffer {
+	b := limitedBufferPool.Get().(*limitedBuffer)
+	b.limit = limit
+	return b
+}
+
+func putLimitedBuffer(b *limitedBuffer) {
+	if b == nil || b.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	b.limit, b.truncated = 0, false
+	limitedBufferPool.Put(b)
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 {
+		if rest := b.limit - b.Buffer.Len(); rest < len(p) {
+			b.truncated = true
+			if rest < 0 {
+				rest = 0
+			}
+			p = p[:rest]
+		}
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readBody reads up to limit bytes of body for logging and returns a replacement body which
+// still yields the full original stream. A limit of zero or less reads the whole body.
+// When restore is false the original body is closed and replaced by an empty one.
+func readBody(body io.ReadCloser, limit int, restore bool) (logged []byte, truncated bool, replaced io.ReadCloser) {
+	if body == nil {
+		return nil, false, nil
+	}
+	var reader io.Reader = body
+	if limit > 0 {
+		reader = io.LimitReader(body, int64(limit)+1)
+	}
+	read, _ := io.ReadAll(reader)
+	if !restore {
+		body.Close()
+		if limit > 0 && len(read) > limit {
+			return read[:limit], true, http.NoBody
+		}
+		return read, false, http.NoBody
+	}
+	if limit > 0 && len(read) > limit {
+		// The body is not consumed yet, so hand the rest of the stream to the handler lazily.
+		return read[:limit], true, &readCloser{Reader: io.MultiReader(bytes.NewReader(read), body), Closer: body}
+	}
+	body.Close()
+	return read, false, io.NopCloser(bytes.NewReader(read))
+}
+
+// timedBody records when a request body was first and last read from.
+type timedBody struct {
+	io.ReadCloser
+	first, last time.Time
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	now := time.Now()
+	if b.first.IsZero() {
+		b.first = now
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.last = time.Now()
+	return n, err
+}
+
+// duration returns the time between the first and the last read, or false when it was never read.
+func (b *timedBody) duration() (time.Duration, bool) {
+	if b.first.IsZero() {
+		return 0, false
+	}
+	return b.last.Sub(b.first), true
+}
+
+// countedBody counts the bytes read from a request body.
+type countedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// bodyLog is a logged body along with its metadata.
+type bodyLog struct {
+	content   []byte
+	truncated bool
+	// encoding is the Content-Encoding of the message, if any.
+	encoding string
+	// contentType is the Content-Type of the message, if any.
+	contentType string
+	// parsed is the content as parsed by a BodyParser, logged in its place.
+	parsed interface{}
+	// text is the content as logged, set by decode, base64 encoded when base64 is set.
+	text   string
+	base64 bool
+}
+
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (b *bodyLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if b.parsed != nil {
+		if err := enc.AddReflected("content", b.parsed); err != nil {
+			return err
+		}
+	} else {
+		enc.AddString("content", b.text)
+	}
+	enc.AddInt("size", len(b.content))
+	if b.truncated {
+		enc.AddBool("truncated", true)
+	}
+	if b.base64 && b.parsed == nil {
+		enc.AddBool("base64", true)
+	}
+	if b.encoding != "" {
+		enc.AddString("encoding", b.encoding)
+	}
+	return nil
+}
+
+func (b *bodyLog) empty() bool {
+	return len(b.content) == 0 && !b.truncated
+}
+
+// addBody adds b to enc as the `body`, `bodyTruncated` and `bodyBase64` fields, or as an object named key with WithNestedBody.
+func addBody(enc zapcore.ObjectEncoder, o *options, key string, b *bodyLog) error {
+	if b.empty() {
+		return nil
+	}
+	parsed, ok := b.parse(o)
+	b.decode(o)
+	if o.nestedBody {
+		b.parsed = parsed
+		return enc.AddObject(key, b)
+	}
+	if ok {
+		return enc.AddReflected("body", parsed)
+	}
+	if len(b.content) != 0 {
+		enc.AddString("body", b.text)
+	}
+	if b.truncated {
+		enc.AddBool("bodyTruncated", true)
+	}
+	if b.base64 {
+		enc.AddBool("bodyBase64", true)
+	}
+	return nil
+}
+
+// parse parses the content with the BodyParser registered for its media type. ok is false when there is
+// none, or when the content is truncated, compressed or does not parse, and should be logged as is.
+func (b *bodyLog) parse(o *options) (parsed interface{}, ok bool) {
+	if len(o.bodyParsers) == 0 || len(b.content) == 0 || b.truncated || b.encoding != "" {
+		return nil, false
+	}
+	mediaType, _, err := mime.ParseMediaType(b.contentType)
+	if err != nil {
+		return nil, false
+	}
+	parser, ok := o.bodyParsers[mediaType]
+	if !ok && strings.HasSuffix(mediaType, "+json") {
+		// JSON based types such as application/problem+json
+		parser, ok = o.bodyParsers["application/json"]
+	}
+	if !ok {
+		return nil, false
+	}
+	parsed, err = parser(b.content)
+	if err != nil || parsed == nil {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// firstLines returns the first n lines of text followed by "...", or text as is when it has no more
+// than n lines besides trailing blank ones.
+func firstLines(text string, n int) string {
+	end := 0
+	for i := 0; i < n; i++ {
+		next := strings.IndexByte(text[end:], '\n')
+		if next < 0 {
+			return text
+		}
+		end += next + 1
+	}
+	if strings.TrimSpace(text[end:]) == "" {
+		return text
+	}
+	return strings.TrimRight(text[:end], "\r\n") + "..."
+}
+
+// BodyParser parses a body into a value logged as a structured object in place of the raw body,
+// see WithBodyParser. The value is logged with zap.Reflect, so it should encode to JSON.
+type BodyParser func(body []byte) (interface{}, error)
+
+// ParseJSONBody is a BodyParser for JSON bodies. Numbers are kept as written.
+func ParseJSONBody(body []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("httplog: invalid JSON after top-level value")
+	}
+	return v, nil
+}
+
+// ParseFormBody is a BodyParser for URL-encoded form bodies. A field with a single value is logged as
+// a string, one with several values as an array of strings.
+func ParseFormBody(body []byte) (interface{}, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	form := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if len(v) == 1 {
+			form[k] = v[0]
+		} else {
+			form[k] = v
+		}
+	}
+	return form, nil
+}
+
+// largeBodyTee forwards a response body to body unless the Content-Length announced by the handler,
+// known on the first write, is over max.
+type largeBodyTee struct {
+	body   io.Writer
+	header http.Header
+	max    int64
+
+	decided bool
+	skipped bool
+}
+
+func (t *largeBodyTee) Write(p []byte) (int, error) {
+	if !t.decided {
+		t.decided = true
+		if n, err := strconv.ParseInt(t.header.Get("Content-Length"), 10, 64); err == nil && n > t.max {
+			t.skipped = true
+		}
+	}
+	if t.skipped {
+		return len(p), nil
+	}
+	return t.body.Write(p)
+}
+
+// statusTee forwards a response body to body only when keep accepts the status, known on the first write,
+// so that bodies which are not logged, such as those of 204 and 304 responses, are not buffered.
+type statusTee struct {
+	body   io.Writer
+	status func() int
+	keep   func(status int) bool
+
+	decided bool
+	skipped bool
+}
+
+func (t *statusTee) Write(p []byte) (int, error) {
+	if !t.decided {
+		t.decided = true
+		t.skipped = !t.keep(t.status())
+	}
+	if t.skipped {
+		return len(p), nil
+	}
+	return t.body.Write(p)
+}
+
+// responseTees holds the writers the middleware tees a response body to, so that they take a single allocation,
+// which is pooled. The request body wrappers are not, as the request log may still read from them.
+type responseTees struct {
+	status statusTee
+	large  largeBodyTee
+	stream streamTee
+}
+
+var responseTeesPool = sync.Pool{
+	New: func() interface{} { return new(responseTees) },
+}
+
+// putResponseTees returns t to the pool once the completion log is written.
+// A handler must not write to the response after it returns anyway.
+func putResponseTees(t *responseTees) {
+	*t = responseTees{}
+	responseTeesPool.Put(t)
+}
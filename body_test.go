@@ -0,0 +1,447 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestStatusTee(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{http.StatusOK, "body"},
+		{http.StatusNoContent, ""},
+		{http.StatusNotModified, ""},
+		{http.StatusNotFound, "body"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		entry := &zapLogEntry{options: newOptions(nil), bodySampled: true}
+		tee := &statusTee{body: &buf, status: func() int { return tt.status }, keep: entry.logResponseBody}
+		tee.Write([]byte("bo"))
+		tee.Write([]byte("dy"))
+		if got := buf.String(); got != tt.want {
+			t.Errorf("status %d: buffered %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestBodilessResponseBody(t *testing.T) {
+	tests := []struct {
+		status int
+		logged bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNoContent, false},
+		{http.StatusNotModified, false},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		serve(logger, writeBody(tt.status, "unexpected"), httptest.NewRequest(http.MethodGet, "/", nil))
+		response := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")
+		if _, ok := response["body"]; ok != tt.logged {
+			t.Errorf("status %d: body logged %v, want %v", tt.status, ok, tt.logged)
+		}
+	}
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	const huge = 8 << 20
+	tests := []struct {
+		name      string
+		opts      []Option
+		size      int
+		logged    int
+		truncated bool
+	}{
+		{"default limit", nil, huge, DefaultMaxBodyBytes, true},
+		{"within default limit", nil, 1 << 10, 1 << 10, false},
+		{"custom limit", []Option{WithMaxBodyBytes(100)}, huge, 100, true},
+		{"no limit", []Option{WithMaxBodyBytes(0)}, 1 << 20, 1 << 20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			body := &countingReader{Reader: bytes.NewReader(bytes.Repeat([]byte("a"), tt.size))}
+			var readBeforeHandler, received int
+			h := func(w http.ResponseWriter, r *http.Request) {
+				readBeforeHandler = body.n
+				b, _ := io.ReadAll(r.Body)
+				received = len(b)
+				w.Write(b)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", body)
+			serve(logger, http.HandlerFunc(h), r, tt.opts...)
+
+			if received != tt.size {
+				t.Errorf("handler received %d bytes, want %d", received, tt.size)
+			}
+			if tt.truncated && readBeforeHandler > tt.logged+1 {
+				t.Errorf("read %d bytes for logging, want at most %d", readBeforeHandler, tt.logged+1)
+			}
+			request := object(t, loggedFields(t, logs, "Request started"), "httpRequest")
+			response := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")
+			for name, log := range map[string]map[string]interface{}{"request": request, "response": response} {
+				if got := len(log["body"].(string)); got != tt.logged {
+					t.Errorf("%s body logged with %d bytes, want %d", name, got, tt.logged)
+				}
+				if got := log["bodyTruncated"] == true; got != tt.truncated {
+					t.Errorf("%s bodyTruncated = %v, want %v", name, got, tt.truncated)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseBodyStatuses(t *testing.T) {
+	tests := []struct {
+		status int
+		logged bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusCreated, false},
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, false},
+		{http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		serve(logger, writeBody(tt.status, "body"), httptest.NewRequest(http.MethodGet, "/", nil),
+			WithResponseBodyStatuses([]int{http.StatusBadRequest, http.StatusInternalServerError}))
+		response := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")
+		if _, ok := response["body"]; ok != tt.logged {
+			t.Errorf("status %d: body logged %v, want %v", tt.status, ok, tt.logged)
+		}
+	}
+}
+
+func TestRequestBodyCaptureMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      BodyCaptureMode
+		read      int
+		status    int
+		started   interface{}
+		completed interface{}
+	}{
+		{"eager", BodyCaptureEager, 0, http.StatusOK, "request body", nil},
+		{"tee", BodyCaptureTee, 7, http.StatusOK, nil, "request"},
+		{"tee unread", BodyCaptureTee, 0, http.StatusOK, nil, nil},
+		{"tee on error success", BodyCaptureTeeOnError, 7, http.StatusOK, nil, nil},
+		{"tee on error failure", BodyCaptureTeeOnError, 7, http.StatusBadRequest, nil, "request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				io.ReadFull(r.Body, make([]byte, tt.read))
+				w.WriteHeader(tt.status)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("request body"))
+			serve(logger, http.HandlerFunc(h), r, WithRequestBodyCaptureMode(tt.mode))
+			if got := object(t, loggedFields(t, logs, "Request started"), "httpRequest")["body"]; got != tt.started {
+				t.Errorf("start log body = %v, want %v", got, tt.started)
+			}
+			if got := loggedFields(t, logs, "Request complete")["requestBody"]; got != tt.completed {
+				t.Errorf("completion log requestBody = %v, want %v", got, tt.completed)
+			}
+		})
+	}
+}
+
+func TestNestedRequestBody(t *testing.T) {
+	eager, tee := WithRequestBodyCaptureMode(BodyCaptureEager), WithRequestBodyCaptureMode(BodyCaptureTee)
+	tests := []struct {
+		name  string
+		outer []Option
+		inner []Option
+		// outerBody and innerBody tell which instance logs the request body.
+		outerBody, innerBody bool
+	}{
+		{"eager", []Option{eager}, []Option{eager}, true, false},
+		{"tee", []Option{tee}, []Option{tee}, true, false},
+		{"eager outside tee", []Option{eager}, []Option{tee}, true, false},
+		{"tee outside eager", []Option{tee}, []Option{eager}, true, false},
+		{"outer body logging off", []Option{WithBodyLogging(false)}, []Option{eager}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outerLogger, outerLogs := newObservedLogger(zapcore.DebugLevel)
+			innerLogger, innerLogs := newObservedLogger(zapcore.DebugLevel)
+			var read []byte
+			h := func(w http.ResponseWriter, r *http.Request) {
+				read, _ = io.ReadAll(r.Body)
+			}
+			inner := ZapRequestLogger(innerLogger, tt.inner...)(http.HandlerFunc(h))
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("request body"))
+			serve(outerLogger, inner, r, tt.outer...)
+
+			if string(read) != "request body" {
+				t.Errorf("handler read %q, want the whole body", read)
+			}
+			for _, instance := range []struct {
+				name string
+				logs *observer.ObservedLogs
+				want bool
+			}{{"outer", outerLogs, tt.outerBody}, {"inner", innerLogs, tt.innerBody}} {
+				started, completed := loggedFields(t, instance.logs, "Request started"), loggedFields(t, instance.logs, "Request complete")
+				_, eagerBody := object(t, started, "httpRequest")["body"]
+				_, teeBody := completed["requestBody"]
+				if got := eagerBody || teeBody; got != instance.want {
+					t.Errorf("%s instance logs the request body %v, want %v", instance.name, got, instance.want)
+				}
+				if eagerBody && teeBody {
+					t.Errorf("%s instance logs the request body twice", instance.name)
+				}
+			}
+		})
+	}
+}
+
+func TestBodyParser(t *testing.T) {
+	parsers := []Option{
+		WithBodyParser("application/json", ParseJSONBody),
+		WithBodyParser("application/x-www-form-urlencoded", ParseFormBody),
+	}
+	tests := []struct {
+		name        string
+		opts        []Option
+		contentType string
+		encoding    string
+		body        string
+		want        interface{}
+	}{
+		{"JSON", nil, "application/json", "", `{"id":1,"tags":["a"]}`,
+			map[string]interface{}{"id": json.Number("1"), "tags": []interface{}{"a"}}},
+		{"JSON with charset", nil, "application/json; charset=utf-8", "", `[1.50]`, []interface{}{json.Number("1.50")}},
+		{"+json", nil, "application/problem+json", "", `{"title":"oops"}`, map[string]interface{}{"title": "oops"}},
+		{"form", nil, "application/x-www-form-urlencoded", "", `a=1&b=2&b=3`,
+			map[string]interface{}{"a": "1", "b": []string{"2", "3"}}},
+		{"invalid JSON", nil, "application/json", "", `{"id":`, `{"id":`},
+		{"JSON with trailing data", nil, "application/json", "", `{} {}`, `{} {}`},
+		{"invalid form", nil, "application/x-www-form-urlencoded", "", `a=%zz`, `a=%zz`},
+		{"no parser", nil, "text/plain", "", `{"id":1}`, `{"id":1}`},
+		{"truncated", []Option{WithMaxBodyBytes(5)}, "application/json", "", `{"id":1}`, `{"id"`},
+		{"compressed", nil, "application/json", "br", `{"id":1}`, `{"id":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Write([]byte(tt.body))
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("Content-Encoding", tt.encoding)
+			serve(logger, http.HandlerFunc(h), r, append(parsers, tt.opts...)...)
+
+			request := object(t, loggedFields(t, logs, "Request started"), "httpRequest")
+			response := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")
+			for name, log := range map[string]map[string]interface{}{"request": request, "response": response} {
+				if !reflect.DeepEqual(log["body"], tt.want) {
+					t.Errorf("%s body = %#v, want %#v", name, log["body"], tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBodyLoggingSkipped(t *testing.T) {
+	serveFile := func(contentType string, size int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(bytes.Repeat([]byte("a"), size)))
+		}
+	}
+	opts := []Option{WithMaxResponseContentLength(1 << 10), WithStreaming(0)}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		skipped interface{}
+		logged  bool
+	}{
+		{"large file", serveFile("application/octet-stream", 1<<20), "large", false},
+		{"small file", serveFile("text/plain", 10), nil, true},
+		{"stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: 1\n\n"))
+		}, "streaming", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			w := serve(logger, tt.handler, httptest.NewRequest(http.MethodGet, "/", nil), opts...)
+			response := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")
+			if got := response["bodyLoggingSkipped"]; got != tt.skipped {
+				t.Errorf("bodyLoggingSkipped = %v, want %v", got, tt.skipped)
+			}
+			if _, ok := response["body"]; ok != tt.logged {
+				t.Errorf("body logged %v, want %v", ok, tt.logged)
+			}
+			if got := response["bytes"]; got != w.Body.Len() {
+				t.Errorf("bytes = %v, want %d", got, w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestBodyUnavailable(t *testing.T) {
+	consume := func(getBody bool) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				if getBody {
+					r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil }
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	none := func(next http.Handler) http.Handler { return next }
+	tests := []struct {
+		name        string
+		before      func(http.Handler) http.Handler
+		body        string
+		logged      interface{}
+		unavailable interface{}
+	}{
+		{"not consumed", none, "body", "body", nil},
+		{"consumed", consume(false), "body", nil, true},
+		{"consumed with GetBody", consume(true), "body", "body", nil},
+		{"empty", consume(false), "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := tt.before(ZapRequestLogger(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			request := object(t, loggedFields(t, logs, "Request started"), "httpRequest")
+			if request["body"] != tt.logged {
+				t.Errorf("body = %v, want %v", request["body"], tt.logged)
+			}
+			if request["bodyUnavailable"] != tt.unavailable {
+				t.Errorf("bodyUnavailable = %v, want %v", request["bodyUnavailable"], tt.unavailable)
+			}
+		})
+	}
+}
+
+func TestBodyLogger(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         BodyCaptureMode
+		requestBody  string
+		responseBody string
+		// logged tells whether a "Request bodies" log is written.
+		logged bool
+	}{
+		{"eager", BodyCaptureEager, "request", "response", true},
+		{"tee", BodyCaptureTee, "request", "response", true},
+		{"response only", BodyCaptureEager, "", "response", true},
+		{"no bodies", BodyCaptureEager, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			bodyLogger, bodyLogs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				w.Write([]byte(tt.responseBody))
+			}
+			r := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(tt.requestBody))
+			serve(logger, http.HandlerFunc(h), r, WithBodyLogger(bodyLogger), WithRequestBodyCaptureMode(tt.mode))
+
+			for _, e := range logs.All() {
+				fields := e.ContextMap()
+				if _, ok := fields["requestBody"]; ok {
+					t.Errorf("%s carries requestBody", e.Message)
+				}
+				for _, key := range []string{"httpRequest", "httpResponse"} {
+					if obj, ok := fields[key].(map[string]interface{}); ok {
+						if _, ok := obj["body"]; ok {
+							t.Errorf("%s carries %s.body", e.Message, key)
+						}
+					}
+				}
+			}
+			if n := bodyLogs.Len(); n != map[bool]int{true: 1, false: 0}[tt.logged] {
+				t.Fatalf("%d body logs, want logged %v", n, tt.logged)
+			}
+			if !tt.logged {
+				return
+			}
+			fields := loggedFields(t, bodyLogs, "Request bodies")
+			if path := object(t, fields, "request")["path"]; path != "/items" {
+				t.Errorf("request.path = %v, want /items", path)
+			}
+			for key, want := range map[string]string{"requestBody": tt.requestBody, "responseBody": tt.responseBody} {
+				if want == "" {
+					if _, ok := fields[key]; ok {
+						t.Errorf("%s logged for an empty body", key)
+					}
+					continue
+				}
+				if got := object(t, fields, key)["content"]; got != want {
+					t.Errorf("%s.content = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestBodyTeeAfterServe(t *testing.T) {
+	logger, logs := newObservedLogger(zapcore.DebugLevel)
+	h := ZapRequestLogger(logger, WithRequestBodyCaptureMode(BodyCaptureTee))
+	rA := httptest.NewRequest(http.MethodPost, "/a", strings.NewReader("-SECRET-OF-A"))
+	original := rA.Body
+	h(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadFull(r.Body, make([]byte, 3))
+	})).ServeHTTP(httptest.NewRecorder(), rA)
+	if rA.Body != original {
+		t.Errorf("the caller's request body is replaced by %T", rA.Body)
+	}
+
+	// The caller of A drains its body while B is served, which must not reach the buffers of B.
+	rB := httptest.NewRequest(http.MethodPost, "/b", strings.NewReader("b"))
+	h(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		io.ReadAll(rA.Body)
+		w.Write([]byte("ok"))
+	})).ServeHTTP(httptest.NewRecorder(), rB)
+	completed := logs.FilterMessage("Request complete").All()
+	if len(completed) != 2 {
+		t.Fatalf("got %d completion logs, want 2", len(completed))
+	}
+	fields := completed[1].ContextMap()
+	if got := object(t, fields, "httpResponse")["body"]; got != "ok" {
+		t.Errorf("response body of B = %v, want ok", got)
+	}
+	if got := fields["requestBody"]; got != "b" {
+		t.Errorf("request body of B = %v, want b", got)
+	}
+}
@@ -0,0 +1,101 @@
+package httplog
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// CharsetDecoder decodes a body in some charset to UTF-8, see WithCharsetDecoder.
+type CharsetDecoder func(body []byte) ([]byte, error)
+
+// defaultCharsetDecoders are the charsets decoded without any WithCharsetDecoder.
+var defaultCharsetDecoders = map[string]CharsetDecoder{
+	"utf-8":      decodeUTF8,
+	"us-ascii":   decodeUTF8,
+	"iso-8859-1": decodeLatin1,
+	"latin1":     decodeLatin1,
+}
+
+func decodeUTF8(body []byte) ([]byte, error) {
+	if !utf8.Valid(body) {
+		return nil, errors.New("httplog: invalid UTF-8")
+	}
+	return body, nil
+}
+
+func decodeLatin1(body []byte) ([]byte, error) {
+	decoded := make([]byte, 0, len(body))
+	for _, c := range body {
+		decoded = utf8.AppendRune(decoded, rune(c))
+	}
+	return decoded, nil
+}
+
+// decode sets the text logged for the content, cut to WithBodyLines.
+func (b *bodyLog) decode(o *options) {
+	b.text, b.base64 = b.decodeText(o)
+	if o.bodyLines > 0 && !b.base64 {
+		b.text = firstLines(b.text, o.bodyLines)
+	}
+}
+
+// decodeText returns the content as text. With WithCharsetDecoding, the content is decoded to UTF-8
+// from the charset of its Content-Type, and is base64 encoded when it is compressed, or neither decodes
+// nor is valid UTF-8 as is.
+func (b *bodyLog) decodeText(o *options) (text string, encoded bool) {
+	if !o.charsetDecoding || len(b.content) == 0 {
+		return string(b.content), false
+	}
+	if b.encoding == "" {
+		if decoded, ok := o.decodeCharset(b.contentType, b.content); ok {
+			return string(decoded), false
+		}
+		content := b.content
+		if b.truncated {
+			// The cut may fall in the middle of a character.
+			content = trimPartialRune(content)
+		}
+		if utf8.Valid(content) {
+			return string(content), false
+		}
+	}
+	return base64.StdEncoding.EncodeToString(b.content), true
+}
+
+// decodeCharset decodes content from the charset parameter of contentType. ok is false when there is
+// no decoder for the charset, or it fails or does not yield valid UTF-8.
+func (o *options) decodeCharset(contentType string, content []byte) (decoded []byte, ok bool) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] == "" {
+		return nil, false
+	}
+	charset := strings.ToLower(params["charset"])
+	decoder, ok := o.charsetDecoders[charset]
+	if !ok {
+		decoder, ok = defaultCharsetDecoders[charset]
+	}
+	if !ok {
+		return nil, false
+	}
+	decoded, err = decoder(content)
+	if err != nil || !utf8.Valid(decoded) {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence at the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
@@ -0,0 +1,120 @@
+package httplog
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// decodeUTF16LE stands for the decoders of golang.org/x/text in the tests.
+func decodeUTF16LE(body []byte) ([]byte, error) {
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(body[2*i:])
+	}
+	return []byte(string(utf16.Decode(units))), nil
+}
+
+func TestCharsetDecoding(t *testing.T) {
+	latin1, invalid := "caf\xe9", "\xff\xfe"
+	utf16le := "c\x00a\x00f\x00\xe9\x00"
+	decoding := WithCharsetDecoding(true)
+	tests := []struct {
+		name        string
+		opts        []Option
+		contentType string
+		encoding    string
+		body        string
+		want        string
+		base64      bool
+	}{
+		{"latin1", []Option{decoding}, "text/plain; charset=ISO-8859-1", "", latin1, "café", false},
+		{"utf-8", []Option{decoding}, "text/plain; charset=utf-8", "", "café", "café", false},
+		{"invalid utf-8", []Option{decoding}, "text/plain; charset=utf-8", "", invalid, base64.StdEncoding.EncodeToString([]byte(invalid)), true},
+		{"unknown charset", []Option{decoding}, "text/plain; charset=windows-1252", "", latin1, base64.StdEncoding.EncodeToString([]byte(latin1)), true},
+		{"unknown charset, valid utf-8", []Option{decoding}, "text/plain; charset=windows-1252", "", "cafe", "cafe", false},
+		{"no charset", []Option{decoding}, "application/octet-stream", "", invalid, base64.StdEncoding.EncodeToString([]byte(invalid)), true},
+		{"registered decoder", []Option{WithCharsetDecoder("utf-16le", decodeUTF16LE)}, "text/plain; charset=UTF-16LE", "", utf16le, "café", false},
+		{"compressed", []Option{decoding}, "text/plain; charset=utf-8", "gzip", "cafe", base64.StdEncoding.EncodeToString([]byte("cafe")), true},
+		{"decoding off", nil, "text/plain; charset=iso-8859-1", "", latin1, latin1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			var read string
+			h := func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				read = string(b)
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Write([]byte(tt.body))
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("Content-Encoding", tt.encoding)
+			serve(logger, http.HandlerFunc(h), r, tt.opts...)
+
+			if read != tt.body {
+				t.Errorf("handler read %q, want the body untouched", read)
+			}
+			for _, logged := range []struct {
+				name string
+				obj  map[string]interface{}
+			}{
+				{"request", object(t, loggedFields(t, logs, "Request started"), "httpRequest")},
+				{"response", object(t, loggedFields(t, logs, "Request complete"), "httpResponse")},
+			} {
+				if got := logged.obj["body"]; got != tt.want {
+					t.Errorf("%s body = %q, want %q", logged.name, got, tt.want)
+				}
+				if got := logged.obj["bodyBase64"] == true; got != tt.base64 {
+					t.Errorf("%s bodyBase64 = %v, want %v", logged.name, got, tt.base64)
+				}
+			}
+		})
+	}
+}
+
+func TestCharsetDecodingTruncated(t *testing.T) {
+	tests := []struct {
+		name   string
+		nested bool
+		body   string
+		max    int
+		want   string
+		base64 bool
+	}{
+		// The cut falls in the middle of the second é.
+		{"cut in a character", false, "ééé", 3, "é", false},
+		{"cut invalid", false, "\xff\xfe\xfd", 2, base64.StdEncoding.EncodeToString([]byte("\xff\xfe")), true},
+		{"nested", true, "\xff\xfe\xfd", 2, base64.StdEncoding.EncodeToString([]byte("\xff\xfe")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			serve(logger, writeBody(http.StatusOK, ""), r, WithCharsetDecoding(true), WithMaxBodyBytes(tt.max), WithNestedBody(tt.nested))
+
+			body, bodyKey, base64Key, truncatedKey := object(t, loggedFields(t, logs, "Request started"), "httpRequest"), "body", "bodyBase64", "bodyTruncated"
+			if tt.nested {
+				body, bodyKey, base64Key, truncatedKey = object(t, body, "requestBody"), "content", "base64", "truncated"
+			}
+			if got := body[bodyKey]; got != tt.want {
+				t.Errorf("%s = %q, want %q", bodyKey, got, tt.want)
+			}
+			if got := body[base64Key] == true; got != tt.base64 {
+				t.Errorf("%s = %v, want %v", base64Key, got, tt.base64)
+			}
+			if body[truncatedKey] != true {
+				t.Errorf("%s = %v, want true", truncatedKey, body[truncatedKey])
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package httplog
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Coalescer folds identical completion logs, requests with the same method, route and status,
+// that repeat within a time window into one summary line with a `count` field.
+// This keeps retry storms from flooding the logs. Pass it with WithCoalescer,
+// and call Flush on shutdown so pending summaries are not lost.
+type Coalescer struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	groups map[coalesceKey]*coalesceGroup
+}
+
+type coalesceKey struct {
+	method string
+	route  string
+	status int
+}
+
+type coalesceGroup struct {
+	logger *zap.Logger
+	count  int
+	timer  *time.Timer
+}
+
+// NewCoalescer returns a Coalescer folding repeats within window.
+func NewCoalescer(window time.Duration) *Coalescer {
+	return &Coalescer{
+		window: window,
+		groups: make(map[coalesceKey]*coalesceGroup),
+	}
+}
+
+func newCoalesceKey(r *http.Request, status int) coalesceKey {
+	route := routePattern(r)
+	if route == "" {
+		route = r.URL.Path
+	}
+	return coalesceKey{method: r.Method, route: route, status: status}
+}
+
+// admit reports whether the completion log of the request is written. The first request of a window
+// is written as usual, repeats are counted and summarized on logger when the window ends.
+func (c *Coalescer) admit(key coalesceKey, logger *zap.Logger) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, ok := c.groups[key]; ok {
+		g.count++
+		return false
+	}
+	g := &coalesceGroup{logger: logger}
+	g.timer = time.AfterFunc(c.window, func() {
+		c.mu.Lock()
+		if c.groups[key] != g {
+			// already flushed
+			c.mu.Unlock()
+			return
+		}
+		delete(c.groups, key)
+		c.mu.Unlock()
+		g.emit(key)
+	})
+	c.groups[key] = g
+	return true
+}
+
+// Flush writes the summaries of all open windows.
+func (c *Coalescer) Flush() {
+	c.mu.Lock()
+	groups := c.groups
+	c.groups = make(map[coalesceKey]*coalesceGroup)
+	c.mu.Unlock()
+	for key, g := range groups {
+		g.timer.Stop()
+		g.emit(key)
+	}
+}
+
+func (g *coalesceGroup) emit(key coalesceKey) {
+	if g.count == 0 {
+		return
+	}
+	g.logger.Info(
+		"Request complete (coalesced)",
+		zap.String("method", key.method),
+		zap.String("route", key.route),
+		zap.Int("status", key.status),
+		zap.Int("count", g.count),
+	)
+}
@@ -0,0 +1,83 @@
+package httplog
+
+import (
+	"sync"
+	"time"
+)
+
+// Config holds the settings of a middleware which can be changed while it is serving requests,
+// e.g. from an admin endpoint. It is safe for concurrent use: every request reads the current
+// values when it starts, and a change applies to requests started after it.
+type Config struct {
+	mu            sync.RWMutex
+	bodyLogging   bool
+	maxBodyBytes  int
+	slowThreshold time.Duration
+}
+
+// configSnapshot holds the values of a Config read once when a request starts, so that the whole
+// request sees the same values even when they are changed while it is served.
+type configSnapshot struct {
+	bodyLogging   bool
+	maxBodyBytes  int
+	slowThreshold time.Duration
+}
+
+func (c *Config) snapshot() configSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return configSnapshot{
+		bodyLogging:   c.bodyLogging,
+		maxBodyBytes:  c.maxBodyBytes,
+		slowThreshold: c.slowThreshold,
+	}
+}
+
+func newConfig() *Config {
+	return &Config{
+		bodyLogging:  true,
+		maxBodyBytes: DefaultMaxBodyBytes,
+	}
+}
+
+// BodyLogging reports whether request and response bodies are logged.
+func (c *Config) BodyLogging() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.bodyLogging
+}
+
+// SetBodyLogging turns request and response body logging on or off.
+func (c *Config) SetBodyLogging(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bodyLogging = enabled
+}
+
+// MaxBodyBytes returns the upper bound of body bytes read for logging. See WithMaxBodyBytes.
+func (c *Config) MaxBodyBytes() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxBodyBytes
+}
+
+// SetMaxBodyBytes changes the upper bound of body bytes read for logging. See WithMaxBodyBytes.
+func (c *Config) SetMaxBodyBytes(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBodyBytes = n
+}
+
+// SlowThreshold returns the duration over which requests are logged as slow. See WithSlowThreshold.
+func (c *Config) SlowThreshold() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.slowThreshold
+}
+
+// SetSlowThreshold changes the duration over which requests are logged as slow. See WithSlowThreshold.
+func (c *Config) SetSlowThreshold(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slowThreshold = d
+}
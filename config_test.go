@@ -0,0 +1,88 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestConfigReadOncePerRequest(t *testing.T) {
+	logger, logs := newObservedLogger(zapcore.DebugLevel)
+	mw, config := ZapRequestLoggerWithConfig(logger, WithMaxBodyBytes(10), WithRequestBodyCaptureMode(BodyCaptureTee))
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config.SetMaxBodyBytes(5)
+		config.SetBodyLogging(false)
+		buf := make([]byte, 100)
+		n, _ := r.Body.Read(buf)
+		w.Write(buf[:n])
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 20))))
+
+	fields := loggedFields(t, logs, "Request complete")
+	if got := fields["requestBody"]; got != strings.Repeat("a", 10) {
+		t.Errorf("requestBody = %v, want the 10 bytes of the limit the request started with", got)
+	}
+	if got := object(t, fields, "httpResponse")["body"]; got != strings.Repeat("a", 10) {
+		t.Errorf("response body = %v, want the 10 bytes of the limit the request started with", got)
+	}
+}
+
+func TestSlowThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		status    int
+		slow      bool
+		level     zapcore.Level
+	}{
+		{"disabled", 0, http.StatusOK, false, zapcore.InfoLevel},
+		{"fast", time.Hour, http.StatusOK, false, zapcore.InfoLevel},
+		{"slow", time.Millisecond, http.StatusOK, true, zapcore.WarnLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(2 * time.Millisecond)
+				w.WriteHeader(tt.status)
+			}
+			serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodGet, "/", nil), WithSlowThreshold(tt.threshold))
+			entries := logs.FilterMessage("Request complete").All()
+			if len(entries) != 1 {
+				t.Fatalf("got %d completion logs, want 1", len(entries))
+			}
+			if got := entries[0].ContextMap()["slow"] == true; got != tt.slow {
+				t.Errorf("slow = %v, want %v", got, tt.slow)
+			}
+			if entries[0].Level != tt.level {
+				t.Errorf("level = %v, want %v", entries[0].Level, tt.level)
+			}
+		})
+	}
+}
+
+func TestConfigConcurrentChanges(t *testing.T) {
+	logger, _ := newObservedLogger(zapcore.DebugLevel)
+	mw, config := ZapRequestLoggerWithConfig(logger)
+	h := mw(writeBody(http.StatusOK, "body"))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			config.SetBodyLogging(i%2 == 0)
+			config.SetMaxBodyBytes(i)
+			config.SetSlowThreshold(time.Duration(i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	wg.Wait()
+}
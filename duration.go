@@ -0,0 +1,51 @@
+package httplog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// DurationFormat is how the `elapsed` field is written, see WithDurationFormat.
+type DurationFormat int
+
+const (
+	// DurationNative writes the duration with the EncodeDuration of the encoder's config.
+	DurationNative DurationFormat = iota
+	// DurationMillis writes the duration as a number of milliseconds, with a fraction.
+	DurationMillis
+	// DurationGCP writes the duration as a string of seconds as Google Cloud expects, e.g. "0.123456789s".
+	DurationGCP
+	// DurationISO8601 writes the duration as an ISO 8601 string of seconds, e.g. "PT0.123456789S".
+	DurationISO8601
+)
+
+// addDuration adds d to enc in format f.
+func addDuration(enc zapcore.ObjectEncoder, key string, d time.Duration, f DurationFormat) {
+	switch f {
+	case DurationMillis:
+		enc.AddFloat64(key, float64(d)/float64(time.Millisecond))
+	case DurationGCP:
+		enc.AddString(key, formatSeconds(d)+"s")
+	case DurationISO8601:
+		enc.AddString(key, "PT"+formatSeconds(d)+"S")
+	default:
+		enc.AddDuration(key, d)
+	}
+}
+
+// formatSeconds formats d as a decimal number of seconds with up to nanosecond precision, e.g. "1.5".
+func formatSeconds(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	s := sign + strconv.FormatInt(int64(d/time.Second), 10)
+	if nanos := int64(d % time.Second); nanos != 0 {
+		frac := strconv.FormatInt(nanos+int64(time.Second), 10)[1:]
+		s += "." + strings.TrimRight(frac, "0")
+	}
+	return s
+}
@@ -0,0 +1,44 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestDurationFormat(t *testing.T) {
+	tests := []struct {
+		format DurationFormat
+		d      time.Duration
+		want   interface{}
+	}{
+		{DurationNative, 1500 * time.Millisecond, 1500 * time.Millisecond},
+		{DurationMillis, 1500 * time.Microsecond, 1.5},
+		{DurationGCP, 123456789 * time.Nanosecond, "0.123456789s"},
+		{DurationGCP, 1500 * time.Millisecond, "1.5s"},
+		{DurationGCP, 2 * time.Second, "2s"},
+		{DurationGCP, -1500 * time.Millisecond, "-1.5s"},
+		{DurationISO8601, 1500 * time.Millisecond, "PT1.5S"},
+		{DurationISO8601, 0, "PT0S"},
+	}
+	for _, tt := range tests {
+		enc := zapcore.NewMapObjectEncoder()
+		addDuration(enc, "elapsed", tt.d, tt.format)
+		if got := enc.Fields["elapsed"]; got != tt.want {
+			t.Errorf("format %d of %v: %#v, want %#v", tt.format, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestDurationFormatElapsed(t *testing.T) {
+	logger, logs := newObservedLogger(zapcore.DebugLevel)
+	serve(logger, writeBody(http.StatusOK, ""), httptest.NewRequest(http.MethodGet, "/", nil), WithDurationFormat(DurationGCP))
+	elapsed, _ := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")["elapsed"].(string)
+	if !strings.HasSuffix(elapsed, "s") || strings.HasPrefix(elapsed, "-") {
+		t.Errorf("elapsed = %q, want seconds such as \"0.000123s\"", elapsed)
+	}
+}
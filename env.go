@@ -0,0 +1,92 @@
+package httplog
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// FromEnv builds the middleware from environment variables, for twelve-factor apps.
+// Unset variables keep the defaults of ZapRequestLogger, and opts are applied after them.
+// The recognized variables are:
+//
+//	HTTPLOG_BODY=true|false                         WithBodyLogging
+//	HTTPLOG_MAX_BODY_BYTES=65536                    WithMaxBodyBytes
+//	HTTPLOG_BODY_SAMPLE_RATE=0.01                   WithBodySampleRate
+//	HTTPLOG_BODY_EXCLUDE_PATHS=/admin/*,/internal/* WithBodyExcludePaths
+//	HTTPLOG_MASK_HEADERS=X-Api-Key,X-Token          WithMaskHeaders
+//	HTTPLOG_SKIP_METHODS=OPTIONS,HEAD               WithSkipMethods
+//	HTTPLOG_TRUST_PROXY=true|false                  WithTrustProxy
+//
+// An invalid value is reported as an error.
+func FromEnv(logger *zap.Logger, opts ...Option) (func(next http.Handler) http.Handler, error) {
+	envOpts, err := optionsFromEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return ZapRequestLogger(logger, append(envOpts, opts...)...), nil
+}
+
+func optionsFromEnv(lookup func(string) (string, bool)) ([]Option, error) {
+	var opts []Option
+	if v, ok := lookup("HTTPLOG_BODY"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, envError("HTTPLOG_BODY", v, err)
+		}
+		opts = append(opts, WithBodyLogging(b))
+	}
+	if v, ok := lookup("HTTPLOG_MAX_BODY_BYTES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, envError("HTTPLOG_MAX_BODY_BYTES", v, err)
+		}
+		opts = append(opts, WithMaxBodyBytes(n))
+	}
+	if v, ok := lookup("HTTPLOG_BODY_SAMPLE_RATE"); ok {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err == nil && (rate < 0 || rate > 1) {
+			err = fmt.Errorf("must be between 0 and 1")
+		}
+		if err != nil {
+			return nil, envError("HTTPLOG_BODY_SAMPLE_RATE", v, err)
+		}
+		opts = append(opts, WithBodySampleRate(rate))
+	}
+	if v, ok := lookup("HTTPLOG_BODY_EXCLUDE_PATHS"); ok {
+		opts = append(opts, WithBodyExcludePaths(splitList(v)...))
+	}
+	if v, ok := lookup("HTTPLOG_MASK_HEADERS"); ok {
+		opts = append(opts, WithMaskHeaders(splitList(v)...))
+	}
+	if v, ok := lookup("HTTPLOG_SKIP_METHODS"); ok {
+		opts = append(opts, WithSkipMethods(splitList(v)...))
+	}
+	if v, ok := lookup("HTTPLOG_TRUST_PROXY"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, envError("HTTPLOG_TRUST_PROXY", v, err)
+		}
+		opts = append(opts, WithTrustProxy(b))
+	}
+	return opts, nil
+}
+
+func envError(name, value string, err error) error {
+	return fmt.Errorf("httplog: invalid %s=%q: %w", name, value, err)
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,77 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptionsFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(o *options) bool
+	}{
+		{"none", nil, func(o *options) bool {
+			return reflect.DeepEqual(o.config.snapshot(), newOptions(nil).config.snapshot()) && !o.trustProxy
+		}},
+		{"HTTPLOG_BODY", map[string]string{"HTTPLOG_BODY": "false"}, func(o *options) bool { return !o.config.BodyLogging() }},
+		{"HTTPLOG_MAX_BODY_BYTES", map[string]string{"HTTPLOG_MAX_BODY_BYTES": "1024"}, func(o *options) bool { return o.config.MaxBodyBytes() == 1024 }},
+		{"HTTPLOG_BODY_SAMPLE_RATE", map[string]string{"HTTPLOG_BODY_SAMPLE_RATE": "0.25"}, func(o *options) bool { return o.bodySampleRate == 0.25 }},
+		{"HTTPLOG_BODY_EXCLUDE_PATHS", map[string]string{"HTTPLOG_BODY_EXCLUDE_PATHS": "/admin/*, ,/internal/*"}, func(o *options) bool {
+			return reflect.DeepEqual(o.bodyExcludePaths, []string{"/admin/*", "/internal/*"})
+		}},
+		{"HTTPLOG_MASK_HEADERS", map[string]string{"HTTPLOG_MASK_HEADERS": "X-Api-Key,X-Token"}, func(o *options) bool {
+			_, apiKey := o.maskHeaders["x-api-key"]
+			_, token := o.maskHeaders["x-token"]
+			return apiKey && token
+		}},
+		{"HTTPLOG_SKIP_METHODS", map[string]string{"HTTPLOG_SKIP_METHODS": "options,HEAD"}, func(o *options) bool {
+			return o.skip(httptest.NewRequest(http.MethodOptions, "/", nil)) && o.skip(httptest.NewRequest(http.MethodHead, "/", nil)) &&
+				!o.skip(httptest.NewRequest(http.MethodGet, "/", nil))
+		}},
+		{"HTTPLOG_TRUST_PROXY", map[string]string{"HTTPLOG_TRUST_PROXY": "true"}, func(o *options) bool { return o.trustProxy }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := optionsFromEnv(func(name string) (string, bool) {
+				v, ok := tt.env[name]
+				return v, ok
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(newOptions(opts)) {
+				t.Errorf("options from %v are not applied", tt.env)
+			}
+		})
+	}
+}
+
+func TestOptionsFromEnvInvalid(t *testing.T) {
+	tests := []struct {
+		name, value string
+	}{
+		{"HTTPLOG_BODY", "maybe"},
+		{"HTTPLOG_MAX_BODY_BYTES", "64KiB"},
+		{"HTTPLOG_BODY_SAMPLE_RATE", "often"},
+		{"HTTPLOG_BODY_SAMPLE_RATE", "1.5"},
+		{"HTTPLOG_BODY_SAMPLE_RATE", "-0.1"},
+		{"HTTPLOG_TRUST_PROXY", "yes please"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			_, err := optionsFromEnv(func(name string) (string, bool) {
+				return tt.value, name == tt.name
+			})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !strings.Contains(err.Error(), tt.name) || !strings.Contains(err.Error(), tt.value) {
+				t.Errorf("error %q does not name %s and its value", err, tt.name)
+			}
+		})
+	}
+}
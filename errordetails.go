@@ -0,0 +1,45 @@
+package httplog
+
+import (
+	"context"
+	"sort"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// errorDetailsLog is the application error recorded with LogEntrySetErrorDetails.
+type errorDetailsLog struct {
+	code    string
+	message string
+	fields  map[string]interface{}
+}
+
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (e *errorDetailsLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if e.code != "" {
+		enc.AddString("code", e.code)
+	}
+	enc.AddString("message", e.message)
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := enc.AddReflected(k, e.fields[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogEntrySetErrorDetails records an application error, so handlers report errors the same way: the completion
+// log then has an `error` object with `code`, `message` and the given fields, and is written at Error level
+// whatever the status. The code is omitted when empty. The last call wins.
+func LogEntrySetErrorDetails(ctx context.Context, code string, message string, fields map[string]interface{}) {
+	if entry, ok := entryFromContext(ctx); ok {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.errorDetails = &errorDetailsLog{code: code, message: message, fields: fields}
+	}
+}
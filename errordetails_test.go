@@ -0,0 +1,65 @@
+package httplog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestErrorDetails(t *testing.T) {
+	type details struct {
+		code, message string
+		fields        map[string]interface{}
+	}
+	tests := []struct {
+		name   string
+		status int
+		set    []details
+		// want is the logged error object, nil when there is none.
+		want  map[string]interface{}
+		level zapcore.Level
+	}{
+		{"none", http.StatusOK, nil, nil, zapcore.InfoLevel},
+		{"success status", http.StatusOK, []details{{"E1", "failed", map[string]interface{}{"orderID": 7, "retry": true}}},
+			map[string]interface{}{"code": "E1", "message": "failed", "orderID": 7, "retry": true}, zapcore.ErrorLevel},
+		{"client error status", http.StatusConflict, []details{{"E2", "conflict", nil}},
+			map[string]interface{}{"code": "E2", "message": "conflict"}, zapcore.ErrorLevel},
+		{"empty code", http.StatusOK, []details{{"", "failed", nil}},
+			map[string]interface{}{"message": "failed"}, zapcore.ErrorLevel},
+		{"last call wins", http.StatusOK, []details{{"E1", "first", map[string]interface{}{"a": 1}}, {"E2", "second", nil}},
+			map[string]interface{}{"code": "E2", "message": "second"}, zapcore.ErrorLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				for _, d := range tt.set {
+					LogEntrySetErrorDetails(r.Context(), d.code, d.message, d.fields)
+				}
+				w.WriteHeader(tt.status)
+			}
+			serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			entries := logs.FilterMessage("Request complete").All()
+			if len(entries) != 1 {
+				t.Fatalf("got %d completion logs, want 1", len(entries))
+			}
+			if entries[0].Level != tt.level {
+				t.Errorf("level = %v, want %v", entries[0].Level, tt.level)
+			}
+			got, ok := entries[0].ContextMap()["error"].(map[string]interface{})
+			if ok != (tt.want != nil) || ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("error = %#v, want %#v", entries[0].ContextMap()["error"], tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorDetailsWithoutEntry(t *testing.T) {
+	// Outside of the middleware, the details are dropped.
+	LogEntrySetErrorDetails(context.Background(), "E1", "failed", nil)
+}
@@ -0,0 +1,37 @@
+package httplog
+
+import (
+	"net/http"
+	"time"
+)
+
+// RequestEvent summarizes a completed request for in-process consumers, see WithEventChannel.
+type RequestEvent struct {
+	Method string
+	Path   string
+	// Route is the route pattern chi matched, empty when the request was not routed by chi.
+	Route   string
+	Status  int
+	Bytes   int
+	Elapsed time.Duration
+	// RequestBytes is how many bytes of the request body were read, by the handler or for logging.
+	RequestBytes int64
+	RequestID    string
+}
+
+// sendEvent sends the event of a completed request to ch, dropping it when ch is full.
+func sendEvent(ch chan<- RequestEvent, r *http.Request, requestID string, status, bytes int, requestBytes int64, elapsed time.Duration) {
+	select {
+	case ch <- RequestEvent{
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Route:        routePattern(r),
+		Status:       status,
+		Bytes:        bytes,
+		Elapsed:      elapsed,
+		RequestBytes: requestBytes,
+		RequestID:    requestID,
+	}:
+	default:
+	}
+}
@@ -0,0 +1,62 @@
+package httplog
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+func TestEventChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    RequestEvent
+	}{
+		{"written", func(w http.ResponseWriter, r *http.Request) {
+			io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		}, RequestEvent{Method: "POST", Path: "/items/1", Route: "/items/{id}", Status: http.StatusCreated, Bytes: 7, RequestBytes: 4, RequestID: "req-1"}},
+		{"unread body", writeBody(http.StatusBadRequest, ""),
+			RequestEvent{Method: "POST", Path: "/items/1", Route: "/items/{id}", Status: http.StatusBadRequest, RequestID: "req-1"}},
+		{"implicit status", func(w http.ResponseWriter, r *http.Request) {},
+			RequestEvent{Method: "POST", Path: "/items/1", Route: "/items/{id}", Status: http.StatusOK, RequestID: "req-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := make(chan RequestEvent, 1)
+			router := chi.NewRouter()
+			router.Use(middleware.RequestID, ZapRequestLogger(zap.NewNop(), WithEventChannel(events)))
+			router.Post("/items/{id}", tt.handler)
+			r := httptest.NewRequest(http.MethodPost, "/items/1", strings.NewReader("body"))
+			r.Header.Set("X-Request-Id", "req-1")
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			got := <-events
+			if got.Elapsed <= 0 {
+				t.Errorf("Elapsed = %v, want it measured", got.Elapsed)
+			}
+			got.Elapsed = 0
+			if got != tt.want {
+				t.Errorf("event = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventChannelFull(t *testing.T) {
+	events := make(chan RequestEvent, 1)
+	h := ZapRequestLogger(zap.NewNop(), WithEventChannel(events))(writeBody(http.StatusOK, "ok"))
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if len(events) != 1 {
+		t.Errorf("%d events queued, want the channel capacity of 1", len(events))
+	}
+}
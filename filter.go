@@ -0,0 +1,198 @@
+package httplog
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// filterFields drops the fields rejected by WithFieldFilter.
+func (o *options) filterFields(fields []zap.Field) []zap.Field {
+	if o.fieldFilter == nil {
+		return fields
+	}
+	kept := make([]zap.Field, 0, len(fields))
+	for _, f := range fields {
+		if o.fieldFilter(f.Key) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// filterEncoder wraps enc so it drops the keys rejected by WithFieldFilter, in nested objects as well.
+func (o *options) filterEncoder(enc zapcore.ObjectEncoder) zapcore.ObjectEncoder {
+	if o.fieldFilter == nil {
+		return enc
+	}
+	if _, ok := enc.(*fieldFilterEncoder); ok {
+		return enc
+	}
+	return &fieldFilterEncoder{ObjectEncoder: enc, keep: o.fieldFilter}
+}
+
+// filterObject wraps obj so that it drops the keys rejected by WithFieldFilter, for the objects
+// which do not filter their keys themselves.
+func (o *options) filterObject(obj zapcore.ObjectMarshaler) zapcore.ObjectMarshaler {
+	if o.fieldFilter == nil {
+		return obj
+	}
+	return zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		return obj.MarshalLogObject(o.filterEncoder(enc))
+	})
+}
+
+type fieldFilterEncoder struct {
+	zapcore.ObjectEncoder
+	keep func(key string) bool
+}
+
+func (e *fieldFilterEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	if !e.keep(key) {
+		return nil
+	}
+	return e.ObjectEncoder.AddArray(key, arr)
+}
+
+func (e *fieldFilterEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if !e.keep(key) {
+		return nil
+	}
+	return e.ObjectEncoder.AddObject(key, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		return obj.MarshalLogObject(&fieldFilterEncoder{ObjectEncoder: enc, keep: e.keep})
+	}))
+}
+
+func (e *fieldFilterEncoder) AddBinary(key string, value []byte) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddBinary(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddByteString(key string, value []byte) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddByteString(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddBool(key string, value bool) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddBool(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddComplex128(key string, value complex128) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddComplex128(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddComplex64(key string, value complex64) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddComplex64(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddDuration(key string, value time.Duration) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddDuration(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddFloat64(key string, value float64) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddFloat64(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddFloat32(key string, value float32) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddFloat32(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddInt(key string, value int) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddInt(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddInt64(key string, value int64) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddInt64(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddInt32(key string, value int32) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddInt32(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddInt16(key string, value int16) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddInt16(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddInt8(key string, value int8) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddInt8(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddString(key, value string) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddString(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddTime(key string, value time.Time) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddTime(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddUint(key string, value uint) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddUint(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddUint64(key string, value uint64) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddUint64(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddUint32(key string, value uint32) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddUint32(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddUint16(key string, value uint16) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddUint16(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddUint8(key string, value uint8) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddUint8(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddUintptr(key string, value uintptr) {
+	if e.keep(key) {
+		e.ObjectEncoder.AddUintptr(key, value)
+	}
+}
+
+func (e *fieldFilterEncoder) AddReflected(key string, value interface{}) error {
+	if !e.keep(key) {
+		return nil
+	}
+	return e.ObjectEncoder.AddReflected(key, value)
+}
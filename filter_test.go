@@ -0,0 +1,64 @@
+package httplog
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFieldFilter(t *testing.T) {
+	dropped := map[string]bool{
+		"remoteAddr": true, "user-agent": true, "bytes": true, "auth": true, "email": true,
+		"heapAlloc": true, "size": true, "path": true, "client.address": true,
+	}
+	filter := WithFieldFilter(func(key string) bool { return !dropped[key] })
+	tests := []struct {
+		name string
+		opts []Option
+		// object is the path of the object holding the keys in the completion log.
+		object        []string
+		dropped, kept string
+	}{
+		{"request", nil, []string{"httpRequest"}, "remoteAddr", "method"},
+		{"request header", nil, []string{"httpRequest", "header"}, "user-agent", "accept"},
+		{"response", nil, []string{"httpResponse"}, "bytes", "status"},
+		{"phases", nil, []string{"phases"}, "auth", "db"},
+		{"error", nil, []string{"error"}, "email", "code"},
+		{"runtime", []Option{WithRuntimeStats(time.Second)}, []string{"runtime"}, "heapAlloc", "goroutines"},
+		{"request body", []Option{WithRequestBodyCaptureMode(BodyCaptureTee), WithNestedBody(true)}, []string{"requestBody"}, "size", "content"},
+		{"minimal request", []Option{WithMinimalRequestContext(true)}, []string{"request"}, "path", "method"},
+		{"schema", []Option{WithSchema(OTelSchema())}, []string{"attributes"}, "client.address", "url.path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				LogEntryMarkPhase(r.Context(), "auth")
+				LogEntryMarkPhase(r.Context(), "db")
+				LogEntrySetErrorDetails(r.Context(), "E1", "failed", map[string]interface{}{"email": "a@example.com"})
+				io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("body"))
+			r.Header.Set("User-Agent", "test")
+			r.Header.Set("Accept", "*/*")
+			serve(logger, http.HandlerFunc(h), r, append([]Option{filter}, tt.opts...)...)
+
+			obj := loggedFields(t, logs, "Request complete")
+			for _, key := range tt.object {
+				obj = object(t, obj, key)
+			}
+			if _, ok := obj[tt.dropped]; ok {
+				t.Errorf("%s is logged: %v", tt.dropped, obj)
+			}
+			if _, ok := obj[tt.kept]; !ok {
+				t.Errorf("%s is not logged: %v", tt.kept, obj)
+			}
+		})
+	}
+}
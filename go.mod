@@ -4,10 +4,8 @@ go 1.18
 
 require (
 	github.com/go-chi/chi/v5 v5.0.7
+	go.uber.org/multierr v1.8.0
 	go.uber.org/zap v1.21.0
 )
 
-require (
-	go.uber.org/atomic v1.9.0 // indirect
-	go.uber.org/multierr v1.8.0 // indirect
-)
+require go.uber.org/atomic v1.9.0 // indirect
@@ -0,0 +1,44 @@
+package httplog
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// grpcTrailer returns the value of a gRPC trailer, which handlers either declare in the Trailer
+// header or set with the http.TrailerPrefix.
+func grpcTrailer(header http.Header, key string) string {
+	if v := header.Get(key); v != "" {
+		return v
+	}
+	return header.Get(http.TrailerPrefix + key)
+}
+
+// grpcStatus returns the grpc-status and grpc-message trailers of a gRPC response.
+// ok is false for non-gRPC requests or when the status is missing.
+func grpcStatus(r *http.Request, header http.Header) (code int, message string, ok bool) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		return 0, "", false
+	}
+	code, err := strconv.Atoi(grpcTrailer(header, "Grpc-Status"))
+	if err != nil {
+		return 0, "", false
+	}
+	return code, grpcTrailer(header, "Grpc-Message"), true
+}
+
+// grpcLevel maps a gRPC status code to a log level: server side failures are errors and
+// the other non-OK codes are warnings.
+func grpcLevel(code int) zapcore.Level {
+	switch code {
+	case 0:
+		return zapcore.InfoLevel
+	case 2, 4, 12, 13, 14, 15: // Unknown, DeadlineExceeded, Unimplemented, Internal, Unavailable, DataLoss
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.WarnLevel
+	}
+}
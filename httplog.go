@@ -1,141 +1,882 @@
 package httplog
 
 import (
-	"bytes"
 	"context"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// logEntryCtxKey stores the entry in the request context in place of chi's middleware.LogEntryCtxKey
+// with WithPrivateContextKey.
+type logEntryCtxKey struct{}
+
+// entryFromContext returns the entry of the request, stored under either context key.
+func entryFromContext(ctx context.Context) (*zapLogEntry, bool) {
+	if entry, ok := ctx.Value(logEntryCtxKey{}).(*zapLogEntry); ok {
+		return entry, true
+	}
+	entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*zapLogEntry)
+	return entry, ok
+}
+
+// withLogEntry returns r with entry stored in its context, marking the request body as captured
+// when entry captures it.
+func withLogEntry(r *http.Request, entry *zapLogEntry) *http.Request {
+	if entry.bodySampled && !entry.outerRequestBody {
+		r = r.WithContext(context.WithValue(r.Context(), requestBodyCtxKey{}, true))
+	}
+	if entry.options.privateContextKey {
+		return r.WithContext(context.WithValue(r.Context(), logEntryCtxKey{}, entry))
+	}
+	return middleware.WithLogEntry(r, entry)
+}
+
+// requestBodyCtxKey marks a request whose body is captured by an outer instance of the middleware,
+// so that nested instances do not log it again.
+type requestBodyCtxKey struct{}
+
+func requestBodyCaptured(ctx context.Context) bool {
+	captured, _ := ctx.Value(requestBodyCtxKey{}).(bool)
+	return captured
+}
+
 func LogEntry(ctx context.Context) zap.SugaredLogger {
 	raw := RawLogEntry(ctx)
 	return *raw.Sugar()
 }
 
 func RawLogEntry(ctx context.Context) zap.Logger {
-	entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*zapLogEntry)
+	entry, ok := entryFromContext(ctx)
 	if !ok || entry == nil {
 		return *zap.NewNop()
 	} else {
-		return *entry.Logger
+		logger, _ := entry.loggers()
+		if entry.options.handlerLogCount {
+			logger = logger.WithOptions(zap.Hooks(entry.countHandlerLog))
+		}
+		return *logger
 	}
 }
 
+// DetachedLogEntry returns a logger carrying the fields the request's loggers carry at the time of the call,
+// for work which outlives the request, such as goroutines started by the handler. It is independent of
+// the request: fields added to it do not appear on the completion log, nor do fields set on the request
+// afterwards appear on it. It returns a no-op logger when ctx has no entry.
+func DetachedLogEntry(ctx context.Context) *zap.Logger {
+	entry, ok := entryFromContext(ctx)
+	if !ok || entry == nil {
+		return zap.NewNop()
+	}
+	logger, _ := entry.loggers()
+	return logger
+}
+
+// LogEntryEnable opts the request in to be logged with WithOptIn. It has no effect otherwise.
+func LogEntryEnable(ctx context.Context) {
+	if entry, ok := entryFromContext(ctx); ok {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.enabled = true
+	}
+}
+
+// LogEntrySetField adds a field to the request's log entry. The field is carried by loggers
+// obtained from LogEntry afterwards and by the "Request complete" log.
 func LogEntrySetField(ctx context.Context, key string, value interface{}) {
-	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*zapLogEntry); ok {
-		entry.Logger = entry.Logger.With(zap.Reflect(key, value))
+	if entry, ok := entryFromContext(ctx); ok {
+		entry.with(zap.Reflect(key, value))
 	}
 }
 
+// LogEntrySetFields is like LogEntrySetField for multiple fields.
 func LogEntrySetFields(ctx context.Context, fields map[string]interface{}) {
-	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(*zapLogEntry); ok {
+	if entry, ok := entryFromContext(ctx); ok {
 		for k, v := range fields {
-			entry.Logger = entry.Logger.With(zap.Reflect(k, v))
+			entry.with(zap.Reflect(k, v))
 		}
 	}
 }
 
-func ZapRequestLogger(logger *zap.Logger) func(next http.Handler) http.Handler {
-	f := &zapdLogFormatter{Logger: logger}
+func ZapRequestLogger(logger *zap.Logger, opts ...Option) func(next http.Handler) http.Handler {
+	mw, _ := ZapRequestLoggerWithConfig(logger, opts...)
+	return mw
+}
+
+// ZapRequestLoggerWithConfig is like ZapRequestLogger but also returns the Config of the middleware,
+// which can be changed at runtime.
+func ZapRequestLoggerWithConfig(logger *zap.Logger, opts ...Option) (func(next http.Handler) http.Handler, *Config) {
+	f := newFormatter(logger, newOptions(opts))
+	o := f.options
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			entry := f.NewLogEntry(r)
+			if o.skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !o.completionLog {
+				// Nothing is logged about the response, so leave the writer alone.
+				entry := f.newLogEntry(r)
+				if o.recoverPanics {
+					defer func() {
+						if rvr := recover(); rvr != nil {
+							entry.recovered(w, r, rvr)
+						}
+					}()
+				}
+				next.ServeHTTP(w, withLogEntry(r, entry))
+				return
+			}
+			var body *timedBody
+			if o.requestReadDuration && r.Body != nil && r.Body != http.NoBody {
+				body = &timedBody{ReadCloser: r.Body}
+				r.Body = body
+			}
+			var counted *countedBody
+			if (o.eventChannel != nil || o.metrics != nil) && r.Body != nil && r.Body != http.NoBody {
+				counted = &countedBody{ReadCloser: r.Body}
+				r.Body = counted
+			}
+			entry := f.newLogEntry(r)
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			tees := responseTeesPool.Get().(*responseTees)
 
-			buf := bytes.NewBuffer(make([]byte, 0))
-			ww.Tee(buf)
+			var buf *limitedBuffer
+			var tee io.Writer
+			if entry.bodyLogging {
+				buf = getLimitedBuffer(entry.config.maxBodyBytes)
+				tees.status = statusTee{body: buf, status: ww.Status, keep: entry.logResponseBody}
+				tee = &tees.status
+			}
+			var large *largeBodyTee
+			if buf != nil && o.maxResponseContentLength > 0 {
+				large = &tees.large
+				*large = largeBodyTee{body: tee, header: ww.Header(), max: o.maxResponseContentLength}
+				tee = large
+			}
+			if tee != nil {
+				ww.Tee(tee)
+			}
+			var stream *streamTee
+			if o.streamingTypes != nil {
+				// the stream tee forwards non-streaming responses to buf
+				stream = &tees.stream
+				*stream = streamTee{
+					header:   ww.Header(),
+					types:    o.streamingTypes,
+					interval: o.streamingInterval,
+					progress: entry.streamProgress,
+				}
+				stream.body = tee
+				ww.Tee(stream)
+			}
+			served := withLogEntry(r, entry)
+			entry.served = served
+			var reqBuf *limitedBuffer
+			if o.requestBodyCaptureMode != BodyCaptureEager && entry.bodySampled && !entry.outerRequestBody && r.Body != nil {
+				// The tee goes on the request passed to next only, as served is a copy of r: the caller may
+				// still read r.Body once the buffer is back in the pool.
+				reqBuf = getLimitedBuffer(entry.config.maxBodyBytes)
+				served.Body = &readCloser{Reader: io.TeeReader(r.Body, reqBuf), Closer: r.Body}
+			}
 
 			t1 := time.Now()
 			defer func() {
-				var respBody []byte
-				respBody, _ = ioutil.ReadAll(buf)
-				extra := extraLogEntry{Body: respBody}
+				// Nothing refers to the pooled buffers and tees once the bodies are copied out and the log written.
+				defer putLimitedBuffer(buf)
+				defer putLimitedBuffer(reqBuf)
+				defer putResponseTees(tees)
+				status, elapsed := ww.Status(), time.Since(t1)
+				var extra extraLogEntry
+				if stream != nil && stream.streaming {
+					extra.BodySkipped = "streaming"
+				} else if large != nil && large.skipped {
+					extra.BodySkipped = "large"
+				} else if buf != nil && entry.logResponseBody(status) {
+					body, _ := ioutil.ReadAll(buf)
+					if o.responseBodyPredicate == nil || o.responseBodyPredicate(ww.Header().Get("Content-Type"), body) {
+						extra.Body = body
+						extra.BodyTruncated = buf.truncated
+					}
+				}
+				if body != nil {
+					extra.RequestReadDuration, extra.RequestRead = body.duration()
+				}
+				if reqBuf != nil && (o.requestBodyCaptureMode == BodyCaptureTee || status >= http.StatusBadRequest) {
+					extra.RequestBody = append([]byte(nil), reqBuf.Bytes()...)
+					extra.RequestBodyTruncated = reqBuf.truncated
+				}
 
-				entry.Write(ww.Status(), ww.BytesWritten(), ww.Header(), time.Since(t1), extra)
+				var requestBytes int64
+				if counted != nil {
+					requestBytes = counted.n
+				}
+				if o.eventChannel != nil {
+					status, _ := implicitStatus(r, status)
+					sendEvent(o.eventChannel, r, o.requestID(r), status, ww.BytesWritten(), requestBytes, elapsed)
+				}
+				if o.metrics != nil {
+					observeSizes(o.metrics, r, requestBytes, ww.BytesWritten())
+				}
+				entry.Write(status, ww.BytesWritten(), ww.Header(), elapsed, extra)
 			}()
+			if o.recoverPanics {
+				// Runs before the completion log above, so that it reports the 500.
+				defer func() {
+					if rvr := recover(); rvr != nil {
+						entry.recovered(ww, r, rvr)
+					}
+				}()
+			}
 
-			next.ServeHTTP(ww, middleware.WithLogEntry(r, entry))
+			next.ServeHTTP(ww, served)
 		}
 		return http.HandlerFunc(fn)
-	}
+	}, o.config
 }
 
 type extraLogEntry struct {
-	Body []byte
+	Body          []byte
+	BodyTruncated bool
+	// BodySkipped is the reason the response body was not captured.
+	BodySkipped         string
+	RequestRead         bool
+	RequestReadDuration time.Duration
+	// RequestBody is what the handler read of the request body in BodyCaptureTee mode.
+	RequestBody          []byte
+	RequestBodyTruncated bool
 }
 
 type zapdLogFormatter struct {
 	*zap.Logger
+	options *options
+	// started is when the middleware was built, for WithServerUptime.
+	started time.Time
+}
+
+// LogFormatter returns the formatter behind ZapRequestLogger, to compose it with chi's own logger
+// plumbing, e.g. middleware.RequestLogger and recoverers which report panics through middleware.GetLogEntry:
+//
+//	r.Use(middleware.RequestLogger(httplog.LogFormatter(logger)))
+//	r.Use(middleware.Recoverer)
+//
+// LogEntry and the other context helpers work as with ZapRequestLogger. Options which depend on
+// wrapping the request and response, such as response body logging, skipping requests or
+// WithRequestReadDuration, only take effect with ZapRequestLogger.
+func LogFormatter(logger *zap.Logger, opts ...Option) middleware.LogFormatter {
+	return newFormatter(logger, newOptions(opts))
+}
+
+func newFormatter(logger *zap.Logger, o *options) *zapdLogFormatter {
+	if len(o.staticFields) > 0 {
+		logger = logger.With(o.staticFields...)
+		if o.errorLogger != nil {
+			o.errorLogger = o.errorLogger.With(o.staticFields...)
+		}
+	}
+	return &zapdLogFormatter{Logger: logger, options: o, started: time.Now()}
 }
 
 // implement interface of middleware.LogFormatter https://github.com/go-chi/chi/blob/v5.0.7/middleware/logger.go#L66
 func (l *zapdLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
-	logger := l.Logger.With(
-		zap.Object("httpRequest", &httpRequestLog{Request: r}),
-	)
-	logger.Info("Request started")
-	entry := &zapLogEntry{Logger: logger}
+	return l.newLogEntry(r)
+}
+
+func (l *zapdLogFormatter) newLogEntry(r *http.Request) *zapLogEntry {
+	// The Config is read once, so that a change while the request is served does not apply to half of it.
+	config := l.options.config.snapshot()
+	excluded := matchAnyPath(l.options.bodyExcludePaths, r.URL.Path)
+	bodyLogging := config.bodyLogging && !excluded
+	bodySampled := bodyLogging && sampled(l.options.bodySampleRate)
+	debug := l.options.debugSampleRate > 0 && l.Core().Enabled(zapcore.DebugLevel) && sampled(l.options.debugSampleRate)
+	if debug {
+		// Excluded paths are never body logged, even when debug sampled.
+		bodyLogging = !excluded
+		bodySampled = bodyLogging
+	}
+	outerRequestBody := requestBodyCaptured(r.Context())
+	requestLog := &httpRequestLog{
+		Request:      r,
+		options:      l.options,
+		logBody:      bodySampled && !outerRequestBody && l.options.requestBodyCaptureMode == BodyCaptureEager,
+		maxBodyBytes: config.maxBodyBytes,
+	}
+	var truncatedForSize bool
+	if max := l.options.maxLogSize; max > 0 {
+		truncatedForSize = fitRequestLog(requestLog, max)
+	}
+	requestField := zap.Object("httpRequest", requestLog)
+	// fields are carried by every log of the request, lineFields only by the start and complete logs,
+	// and errorFields only by the complete logs of failed requests.
+	var fields, lineFields, errorFields []zap.Field
+	if l.options.bodyLogger != nil {
+		// The body has to be read before the handler consumes it, as it is logged on completion.
+		requestLog.readBody()
+	}
+	if l.options.adaptiveVerbosity && !debug {
+		fields = append(fields, zap.Object("request", &minimalRequestLog{Request: r, options: l.options}))
+		errorFields = append(errorFields, requestField)
+		// The body has to be read before the handler consumes it, in case the request fails.
+		requestLog.readBody()
+	} else if l.options.minimalRequestContext {
+		fields = append(fields, zap.Object("request", &minimalRequestLog{Request: r, options: l.options}))
+		lineFields = append(lineFields, requestField)
+	} else {
+		fields = append(fields, requestField)
+	}
+	var spanID string
+	if l.options.spanID {
+		spanID = newID()
+		fields = append(fields, zap.String(l.options.spanIDKey, spanID))
+		if parent := parentSpanID(r.Context()); parent != "" {
+			fields = append(fields, zap.String(l.options.parentSpanIDKey, parent))
+		}
+	}
+	start := time.Now()
+	if l.options.acceptHeaders {
+		lineFields = append(lineFields, acceptFields(r.Header, l.options.acceptQuality)...)
+	}
+	if l.options.serverUptime {
+		lineFields = append(lineFields, zap.Duration("serverUptime", start.Sub(l.started)))
+	}
+	if l.options.requestTimeLayout != "" {
+		lineFields = append(lineFields, zap.String("requestTime", start.Format(l.options.requestTimeLayout)))
+	}
+	if l.options.queueTimeHeader != "" {
+		if d, ok := queueTime(r.Header, l.options.queueTimeHeader, start); ok {
+			lineFields = append(lineFields, zap.Duration("queueTime", d))
+		}
+	}
+	if debug {
+		lineFields = append(lineFields, zap.Bool("debugSampled", true))
+	}
+	fields, lineFields = l.options.filterFields(fields), l.options.filterFields(lineFields)
+	entry := &zapLogEntry{
+		Logger:           l.Logger.With(fields...),
+		base:             l.Logger,
+		fields:           fields,
+		lineFields:       lineFields,
+		errorFields:      errorFields,
+		requestLog:       requestLog,
+		truncatedForSize: truncatedForSize,
+		options:          l.options,
+		config:           config,
+		request:          r,
+		start:            start,
+		spanID:           spanID,
+		bodyLogging:      bodyLogging,
+		bodySampled:      bodySampled,
+		outerRequestBody: outerRequestBody,
+		debug:            debug,
+	}
+	if l.options.errorLogger != nil {
+		entry.errorLogger = l.options.errorLogger.With(fields...)
+	}
+	if l.options.onStart != nil {
+		// The entry is in the context, so that fields set by the hook are carried by the start log.
+		l.options.onStart(withLogEntry(r, entry), entry.Logger)
+	}
+	startFields := lineFields
+	if truncatedForSize {
+		startFields = append(startFields[:len(startFields):len(startFields)], zap.Bool("truncatedForSize", true))
+	}
+	level := zapcore.InfoLevel
+	if debug {
+		level = zapcore.DebugLevel
+	}
+	startLog := func() {
+		logger, _ := entry.loggers()
+		if ce := logger.Check(level, "Request started"); ce != nil {
+			ce.Time = start
+			ce.Write(startFields...)
+		}
+	}
+	switch {
+	case !l.options.startLog:
+		// The body is first logged on completion, so read it before the handler consumes it.
+		requestLog.readBody()
+	case !l.options.completionLog || !l.options.deferStartLog && !l.options.optIn:
+		// Without the completion log, nothing would write a deferred start log.
+		startLog()
+	default:
+		// The start log is held back, so read the body before the handler consumes it.
+		requestLog.readBody()
+		entry.startLog = startLog
+	}
 	return entry
 }
 
+// zapLogEntry is shared through the request context by the handler and the deferred Write,
+// so fields added while handling the request appear on the completion log.
 type zapLogEntry struct {
+	mu sync.Mutex
 	*zap.Logger
+	errorLogger *zap.Logger
+	base        *zap.Logger
+	fields      []zap.Field
+	// added are the fields added to the loggers by with, after fields.
+	added       []zap.Field
+	lineFields  []zap.Field
+	errorFields []zap.Field
+	requestLog  *httpRequestLog
+	// truncatedForSize is set when the request was trimmed to fit WithMaxLogSize.
+	truncatedForSize bool
+	// startLog writes the start log deferred by WithDeferredStartLog.
+	startLog func()
+	options  *options
+	// config is the Config as it was when the request started.
+	config  configSnapshot
+	request *http.Request
+	// served is the request handed to the next handler.
+	served      *http.Request
+	start       time.Time
+	spanID      string
+	bodyLogging bool
+	bodySampled bool
+	// outerRequestBody is set when an outer instance of the middleware captures the request body.
+	outerRequestBody bool
+	// debug is set for the requests sampled by WithDebugSampleRate.
+	debug   bool
+	phases  []phaseMark
+	panicID string
+	auth    *authLog
+	// errorDetails is the application error recorded with LogEntrySetErrorDetails.
+	errorDetails *errorDetailsLog
+	// enabled is set by LogEntryEnable for WithOptIn.
+	enabled bool
+	// handlerLogs counts the logs written through LogEntry and RawLogEntry, atomically.
+	handlerLogs int64
+	// responseLog is the response object of the completion log, see Write.
+	responseLog httpResponseLog
+}
+
+func (l *zapLogEntry) with(fields ...zap.Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Logger = l.Logger.With(fields...)
+	l.added = append(l.added[:len(l.added):len(l.added)], fields...)
+	if l.errorLogger != nil {
+		l.errorLogger = l.errorLogger.With(fields...)
+	}
+}
+
+// keep reports whether the completion log is written according to the sampling options.
+// 5xx responses and debug sampled requests are always kept.
+func (l *zapLogEntry) keep(status int) bool {
+	if status >= http.StatusInternalServerError || l.debug {
+		return true
+	}
+	if rate, ok := l.options.statusClassRates[statusClass(status)]; ok && !sampled(rate) {
+		return false
+	}
+	if s := l.options.routeSampler; s != nil && !s.keep(routePattern(l.request), time.Now()) {
+		return false
+	}
+	return true
+}
+
+// optedIn reports whether the request is logged with WithOptIn: it was enabled with LogEntryEnable,
+// or failed with 5xx or a panic.
+func (l *zapLogEntry) optedIn(status int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled || l.panicID != "" || status >= http.StatusInternalServerError
+}
+
+// streamProgress logs how many bytes of a streaming response were written so far.
+func (l *zapLogEntry) streamProgress(bytes int64) {
+	logger, _ := l.loggers()
+	logger.Info("Request streaming", zap.Int64("bytesStreamed", bytes))
+}
+
+// logResponseBody reports whether the buffered response body is logged for status.
+func (l *zapLogEntry) logResponseBody(status int) bool {
+	// 204 and 304 responses carry no body by definition.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if l.options.responseBodyStatuses != nil && !l.debug {
+		if _, ok := l.options.responseBodyStatuses[status]; !ok {
+			return false
+		}
+	}
+	// Bodies of error responses are logged even when the request was not sampled.
+	return l.bodySampled || status >= http.StatusInternalServerError
+}
+
+func (l *zapLogEntry) loggers() (logger, errorLogger *zap.Logger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Logger, l.errorLogger
+}
+
+func (l *zapLogEntry) countHandlerLog(zapcore.Entry) error {
+	atomic.AddInt64(&l.handlerLogs, 1)
+	return nil
+}
+
+// teeRequestBody returns the request body captured while the handler read it, masked.
+func (l *zapLogEntry) teeRequestBody(extra extraLogEntry) *bodyLog {
+	content := extra.RequestBody
+	if masked, ok := maskBody(l.options, l.request, content); ok {
+		content = masked
+	}
+	return &bodyLog{
+		content:     content,
+		truncated:   extra.RequestBodyTruncated,
+		encoding:    l.request.Header.Get("Content-Encoding"),
+		contentType: l.request.Header.Get("Content-Type"),
+	}
+}
+
+// logBodies writes the request and response bodies to the logger of WithBodyLogger, if there are any.
+func (l *zapLogEntry) logBodies(header http.Header, extra interface{}) {
+	fields := []zap.Field{zap.Object("request", &minimalRequestLog{Request: l.request, options: l.options})}
+	add := func(key string, b *bodyLog) {
+		if b.empty() {
+			return
+		}
+		b.parsed, _ = b.parse(l.options)
+		b.decode(l.options)
+		fields = append(fields, zap.Object(key, b))
+	}
+	if l.requestLog.bodyRead {
+		add("requestBody", l.requestLog.bodyLog())
+	}
+	if extra, ok := extra.(extraLogEntry); ok {
+		add("requestBody", l.teeRequestBody(extra))
+		add("responseBody", &bodyLog{
+			content:     extra.Body,
+			truncated:   extra.BodyTruncated,
+			encoding:    header.Get("Content-Encoding"),
+			contentType: header.Get("Content-Type"),
+		})
+	}
+	if len(fields) > 1 {
+		l.options.bodyLogger.Info("Request bodies", fields...)
+	}
+}
+
+// httpVersion returns the HTTP version as commonly written: "1.0", "1.1", then "2" and "3" from HTTP/2 on,
+// which have no minor versions.
+func httpVersion(major, minor int) string {
+	if major >= 2 && minor == 0 {
+		return strconv.Itoa(major)
+	}
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor)
+}
+
+// implicitStatus returns 200 for a status of 0, reported when the handler returned without writing anything,
+// as net/http sends it on the handler's behalf; unless the handler took the connection over for a protocol upgrade.
+func implicitStatus(r *http.Request, status int) (int, bool) {
+	if status == 0 && r.Header.Get("Upgrade") == "" {
+		return http.StatusOK, true
+	}
+	return status, false
 }
 
 // implement interface of middleware.LogEntry https://github.com/go-chi/chi/blob/v5.0.7/middleware/logger.go#L72
 func (l *zapLogEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
-	httpResponseLog := &httpResponseLog{
-		Status:  &status,
-		Bytes:   &bytes,
-		Header:  &header,
-		Elapsed: &elapsed,
-		Extra:   &extra,
-	}
-	l.Logger.Info(
-		"Request complete",
-		zap.Object("httpResponse", httpResponseLog),
-	)
+	status, implicit := implicitStatus(l.request, status)
+	if !l.options.completionLog || !l.keep(status) {
+		return
+	}
+	if l.options.optIn && !l.optedIn(status) {
+		return
+	}
+	if l.options.shouldLog != nil && !l.options.shouldLog(l.request, status, bytes, elapsed) {
+		return
+	}
+	if c := l.options.coalescer; c != nil && !c.admit(newCoalesceKey(l.request, status), l.base) {
+		return
+	}
+	// The response log is part of the entry to save an allocation. It is not pooled, as some cores,
+	// such as zaptest/observer, keep the fields after the log is written.
+	l.responseLog = httpResponseLog{
+		Status:   status,
+		implicit: implicit,
+		Bytes:    bytes,
+		Header:   header,
+		Elapsed:  elapsed,
+		Extra:    extra,
+		request:  l.request,
+		served:   l.served,
+		options:  l.options,
+	}
+	httpResponseLog := &l.responseLog
+	logger, errorLogger := l.loggers()
+	if errorLogger != nil && status >= http.StatusInternalServerError {
+		logger = errorLogger
+	}
+	fields := make([]zap.Field, 0, len(l.lineFields)+len(l.errorFields)+8)
+	fields = append(fields, l.lineFields...)
+	if status >= http.StatusBadRequest {
+		fields = append(fields, l.errorFields...)
+	} else if l.options.adaptiveVerbosity {
+		httpResponseLog.minimal = true
+	}
+	if l.options.schema != nil {
+		for _, f := range l.options.schema(&Completion{
+			Request: l.request,
+			Header:  header,
+			Status:  status,
+			Bytes:   bytes,
+			Start:   l.start,
+			Elapsed: elapsed,
+			options: l.options,
+		}) {
+			if f.Type == zapcore.ObjectMarshalerType {
+				f = zap.Object(f.Key, l.options.filterObject(f.Interface.(zapcore.ObjectMarshaler)))
+			}
+			fields = append(fields, f)
+		}
+	} else {
+		fields = append(fields, zap.Object("httpResponse", httpResponseLog))
+	}
+	l.mu.Lock()
+	phases, panicID, auth, errorDetails := l.phases, l.panicID, l.auth, l.errorDetails
+	l.mu.Unlock()
+	if panicID != "" {
+		fields = append(fields, zap.Bool("panicked", true), zap.String("panicId", panicID))
+	}
+	if l.options.handlerLogCount {
+		fields = append(fields, zap.Int64("handlerLogCount", atomic.LoadInt64(&l.handlerLogs)))
+	}
+	if auth != nil {
+		switch {
+		case auth.subject == "":
+		case l.options.maskAuthSubject && l.options.masking:
+			fields = append(fields, zap.String("authSubject", maskedString))
+		default:
+			fields = append(fields, zap.String("authSubject", auth.subject))
+		}
+		fields = append(fields, zap.Bool("authenticated", auth.authenticated))
+	}
+	if len(phases) > 0 {
+		fields = append(fields, zap.Object("phases", l.options.filterObject(&phasesLog{start: l.start, marks: phases})))
+	}
+	if errorDetails != nil {
+		fields = append(fields, zap.Object("error", l.options.filterObject(errorDetails)))
+	}
+	if extra, ok := extra.(extraLogEntry); ok && l.options.bodyLogger == nil {
+		body := l.teeRequestBody(extra)
+		parsed, isParsed := body.parse(l.options)
+		body.decode(l.options)
+		switch {
+		case body.empty():
+		case l.options.nestedBody:
+			body.parsed = parsed
+			fields = append(fields, zap.Object("requestBody", l.options.filterObject(body)))
+		case isParsed:
+			fields = append(fields, zap.Reflect("requestBody", parsed))
+		default:
+			if len(body.content) != 0 {
+				fields = append(fields, zap.String("requestBody", body.text))
+			}
+			if body.truncated {
+				fields = append(fields, zap.Bool("requestBodyTruncated", true))
+			}
+			if body.base64 {
+				fields = append(fields, zap.Bool("requestBodyBase64", true))
+			}
+		}
+	}
+	if l.options.bodyLogger != nil {
+		httpResponseLog.omitBody = true
+		l.logBodies(header, extra)
+	}
+	if l.options.memStats != nil && status >= http.StatusInternalServerError {
+		fields = append(fields, zap.Object("runtime", l.options.filterObject(&runtimeLog{goroutines: runtime.NumGoroutine(), mem: l.options.memStats.get()})))
+	}
+	if l.options.combinedLog {
+		fields = append(fields, zap.String("accessLog", combinedLogFormat(l.request, l.options.requestURI(l.request), l.start, status, bytes, !l.options.authorizationMasked())))
+	}
+	for _, f := range l.options.responseHeaderFields {
+		if v := header.Get(f.name); v != "" {
+			fields = append(fields, zap.String(f.key, v))
+		}
+	}
+	if l.options.finalizeFields != nil {
+		fields = append(fields, l.options.finalizeFields(l.request, status, bytes, elapsed)...)
+	}
+	level := zapcore.InfoLevel
+	if l.options.grpcStatus {
+		if code, _, ok := grpcStatus(l.request, header); ok {
+			level = grpcLevel(code)
+		}
+	}
+	// A 2xx without content where some was expected often hides a silent failure.
+	if l.options.emptySuccessBody != nil && status >= 200 && status < 300 && status != http.StatusNoContent &&
+		bytes == 0 && l.request.Method != http.MethodHead {
+		fields = append(fields, zap.Bool("emptySuccessBody", true))
+		if *l.options.emptySuccessBody > level {
+			level = *l.options.emptySuccessBody
+		}
+	}
+	if errorDetails != nil {
+		level = zapcore.ErrorLevel
+	}
+	if threshold := l.config.slowThreshold; threshold > 0 && elapsed >= threshold {
+		fields = append(fields, zap.Bool("slow", true))
+		if level < zapcore.WarnLevel {
+			level = zapcore.WarnLevel
+		}
+	}
+	// WithDeferredStartLog wins over WithOptIn: the start log of an opted-in request is only written on failure.
+	if l.startLog != nil && (status >= http.StatusBadRequest || l.options.optIn && !l.options.deferStartLog) {
+		l.startLog()
+	}
+	if l.debug && level == zapcore.InfoLevel {
+		level = zapcore.DebugLevel
+	}
+	if max := l.options.maxLogSize; max > 0 && logger.Core().Enabled(level) {
+		base := l.base
+		if logger == errorLogger {
+			base = l.options.errorLogger
+		}
+		logger, fields = l.fitLogSize(max, logger, base, fields, httpResponseLog)
+	}
+	if ce := logger.Check(level, "Request complete"); ce != nil {
+		ce.Write(l.options.filterFields(fields)...)
+	}
 }
 
 // implement interface of middleware.LogEntry https://github.com/go-chi/chi/blob/v5.0.7/middleware/logger.go#L73
 func (l *zapLogEntry) Panic(v interface{}, stack []byte) {
+	panicID := newID() // correlates the panic log with the completion log written afterwards
+	l.mu.Lock()
+	l.panicID = panicID
+	l.mu.Unlock()
+	logger, _ := l.loggers()
 	// Prevent showing duplicate stacktrace.
 	// One is from zap embedded function, the other is from argument of stack.
-	l.Logger.WithOptions(zap.AddStacktrace(zap.FatalLevel+1)).Error(
+	logger.WithOptions(zap.AddStacktrace(zap.FatalLevel+1)).Error(
 		"Panic",
 		zap.String("panic", fmt.Sprintf("%+v", v)),
 		zap.String("stack", string(stack)),
+		zap.String("panicId", panicID),
 	)
+	if l.options.panicHook != nil {
+		l.options.panicHook(l.request, v, stack)
+	}
+}
+
+// recovered logs a panic recovered with WithRecover and responds 500 in place of the handler, as
+// middleware.Recoverer does. http.ErrAbortHandler is panicked again, as it is meant to abort the response.
+func (l *zapLogEntry) recovered(w http.ResponseWriter, r *http.Request, rvr interface{}) {
+	if rvr == http.ErrAbortHandler {
+		panic(rvr)
+	}
+	l.Panic(rvr, debug.Stack())
+	if r.Header.Get("Connection") != "Upgrade" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
 type httpRequestLog struct {
 	*http.Request
+	options *options
+	logBody bool
+	// maxBodyBytes is the MaxBodyBytes of the Config when the request started.
+	maxBodyBytes int
+	// omitBody and omitHeader drop the body and the header to fit WithMaxLogSize.
+	omitBody   bool
+	omitHeader bool
+
+	// The body is read once and kept, as the object may be marshaled more than once.
+	bodyRead      bool
+	body          []byte
+	bodyTruncated bool
+	// bodyUnavailable is set when the body was consumed before it could be read for logging.
+	bodyUnavailable bool
 }
 
 // implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
 func (r *httpRequestLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc = r.options.filterEncoder(enc)
 	scheme := "http"
 	if r.TLS != nil {
 		scheme = "https"
 	}
+	host := r.Host
+	var clientIP string
+	if r.options.trustProxy {
+		info := forwarded(r.Request)
+		scheme, host, clientIP = info.scheme, info.host, info.clientIP
+	}
 	enc.AddString("method", r.Method)
 	enc.AddString("scheme", scheme)
-	enc.AddString("host", r.Host)
-	enc.AddString("requestURI", r.RequestURI)
+	enc.AddString("host", host)
+	enc.AddString("requestURI", r.options.requestURI(r.Request))
 	enc.AddString("proto", r.Proto)
+	if r.options.protoVersion {
+		enc.AddInt("protoMajor", r.ProtoMajor)
+		enc.AddInt("protoMinor", r.ProtoMinor)
+		enc.AddString("httpVersion", httpVersion(r.ProtoMajor, r.ProtoMinor))
+	}
 	enc.AddString("remoteAddr", r.RemoteAddr)
-	if len(r.Header) > 0 {
-		enc.AddObject("header", &httpHeaderLog{Header: &r.Header})
+	if clientIP != "" {
+		enc.AddString("clientIP", clientIP)
+	}
+	if r.options.localAddr {
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			enc.AddString("localAddr", addr.String())
+		}
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" && r.options.requestContentType {
+		enc.AddString("contentType", contentType)
+	}
+	if r.options.bytesTotal {
+		enc.AddInt64("requestBytesTotal", requestBytesTotal(r.Request))
+	}
+	if r.options.routeNameKey != nil {
+		if name, ok := r.Context().Value(r.options.routeNameKey).(string); ok && name != "" {
+			enc.AddString("routeName", name)
+		}
+	}
+	// Encoders without full support for nested objects report errors here; they are returned
+	// so zap records them as "httpRequestError" instead of silently dropping the fields.
+	var errs error
+	if len(r.Header) > 0 && !r.omitHeader && r.options.requestHeaders {
+		errs = multierr.Append(errs, enc.AddObject("header", &httpHeaderLog{Header: r.Header, options: r.options}))
+	}
+	if r.options.clientCert && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		errs = multierr.Append(errs, enc.AddObject("clientCert", &clientCertLog{Certificate: r.TLS.PeerCertificates[0]}))
+	}
+	if r.options.cookieNames {
+		if names := cookieNames(r.Cookies()); len(names) > 0 {
+			errs = multierr.Append(errs, enc.AddArray("cookies", stringArray(names)))
+		}
+	}
+	if r.options.retryHeader != "" {
+		if v := r.Header.Get(r.options.retryHeader); v != "" {
+			if retry, count := r.options.retryParser(v); retry {
+				enc.AddBool("retry", true)
+				if count > 0 {
+					enc.AddInt("retryCount", count)
+				}
+			}
+		}
 	}
-	reqID := middleware.GetReqID(r.Context())
+	if baggage := parseBaggage(r.Header, r.options.baggageKeys); baggage != nil {
+		errs = multierr.Append(errs, enc.AddObject("baggage", baggage))
+	}
+	reqID := r.options.requestID(r.Request)
 	if reqID != "" {
 		enc.AddString("requestID", reqID)
 	}
@@ -143,56 +884,282 @@ func (r *httpRequestLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	//
 	// log request Body
 	//
-	b := bytes.NewBuffer(make([]byte, 0))
-	reader := io.TeeReader(r.Body, b)
-	bytes, _ := io.ReadAll(reader)
-	defer r.Body.Close()
-	if len(bytes) != 0 {
-		enc.AddString("body", string(bytes))
+	if !r.logBody || r.omitBody {
+		return errs
+	}
+	r.readBody()
+	if r.bodyUnavailable {
+		enc.AddBool("bodyUnavailable", true)
+	}
+	if summary, ok := r.multipart(r.body); ok {
+		errs = multierr.Append(errs, enc.AddArray("multipart", summary))
+	} else if r.options.bodyLogger == nil {
+		errs = multierr.Append(errs, addBody(enc, r.options, "requestBody", r.bodyLog()))
+	}
+
+	return errs
+}
+
+// bodyLog returns the body read by readBody, masked.
+func (r *httpRequestLog) bodyLog() *bodyLog {
+	content := r.body
+	if masked, ok := maskBody(r.options, r.Request, content); ok {
+		content = masked
+	}
+	return &bodyLog{
+		content:     content,
+		truncated:   r.bodyTruncated,
+		encoding:    r.Header.Get("Content-Encoding"),
+		contentType: r.Header.Get("Content-Type"),
+	}
+}
+
+// readBody reads the request body for logging, once.
+func (r *httpRequestLog) readBody() {
+	if r.bodyRead || !r.logBody {
+		return
+	}
+	limit := r.maxBodyBytes
+	var replaced io.ReadCloser
+	r.body, r.bodyTruncated, replaced = readBody(r.Body, limit, r.options.restoreRequestBody)
+	r.Body = replaced
+	r.bodyRead = true
+	if len(r.body) > 0 || r.ContentLength <= 0 {
+		return
+	}
+	// The body was announced but already consumed, most likely by a middleware mounted earlier.
+	// A middleware buffering it can provide a fresh copy through GetBody, for the handler as well.
+	if r.GetBody != nil {
+		if body, err := r.GetBody(); err == nil {
+			r.body, r.bodyTruncated, replaced = readBody(body, limit, r.options.restoreRequestBody)
+			r.Body = replaced
+			if len(r.body) > 0 {
+				return
+			}
+		}
 	}
-	r.Body = io.NopCloser(b)
+	r.bodyUnavailable = true
+}
+
+// minimalRequestLog is the small subset of the request carried by handler logs with WithMinimalRequestContext.
+type minimalRequestLog struct {
+	*http.Request
+	options *options
+}
+
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (r *minimalRequestLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc = r.options.filterEncoder(enc)
+	enc.AddString("method", r.Method)
+	enc.AddString("path", r.URL.Path)
+	if reqID := r.options.requestID(r.Request); reqID != "" {
+		enc.AddString("requestID", reqID)
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" && r.options.requestContentType {
+		enc.AddString("contentType", contentType)
+	}
+	return nil
+}
+
+func (r *httpRequestLog) multipart(body []byte) (*multipartLog, bool) {
+	if !r.options.multipartSummary {
+		return nil, false
+	}
+	return parseMultipart(r.Header.Get("Content-Type"), body)
+}
+
+type clientCertLog struct {
+	*x509.Certificate
+}
 
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (c *clientCertLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	// Only the leaf certificate is logged, never the whole chain.
+	enc.AddString("subject", c.Subject.CommonName)
+	enc.AddString("issuer", c.Issuer.CommonName)
+	enc.AddString("serial", c.SerialNumber.Text(16))
 	return nil
 }
 
 type httpResponseLog struct {
-	Status  *int
-	Bytes   *int
-	Header  *http.Header
-	Elapsed *time.Duration
-	Extra   *interface{}
+	Status  int
+	Bytes   int
+	Header  http.Header
+	Elapsed time.Duration
+	Extra   interface{}
+	request *http.Request
+	options *options
+	// served is the request handed to the next handler, which a router may have updated.
+	served *http.Request
+	// implicit is set when the handler wrote nothing and the status is the one net/http sends for it.
+	implicit bool
+	// minimal limits the object to the status and the elapsed time.
+	minimal bool
+	// omitBody and omitHeader drop the body and the header to fit WithMaxLogSize.
+	omitBody   bool
+	omitHeader bool
 }
 
 // implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
 func (r *httpResponseLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
-	enc.AddInt("status", *r.Status)
-	enc.AddInt("bytes", *r.Bytes)
-	enc.AddDuration("elapsed", *r.Elapsed)
-	if len(*r.Header) > 0 {
-		enc.AddObject("header", &httpHeaderLog{Header: r.Header})
+	enc = r.options.filterEncoder(enc)
+	enc.AddInt("status", r.Status)
+	if r.implicit {
+		enc.AddBool("statusImplicit", true)
+	}
+	if r.minimal {
+		r.addElapsed(enc)
+		return nil
+	}
+	if r.options.statusClass && r.Status > 0 {
+		enc.AddString("statusClass", statusClass(r.Status))
+	}
+	enc.AddInt("bytes", r.Bytes)
+	if r.options.bytesTotal {
+		enc.AddInt64("responseBytesTotal", responseBytesTotal(r.request.Proto, r.Status, r.Header, r.Bytes))
+	}
+	r.addElapsed(enc)
+	var errs error
+	if len(r.Header) > 0 && !r.omitHeader && r.options.responseHeaders {
+		errs = multierr.Append(errs, enc.AddObject("header", &httpHeaderLog{Header: r.Header, options: r.options}))
+	}
+	if r.options.cookieNames && !r.omitHeader {
+		if names := cookieNames((&http.Response{Header: r.Header}).Cookies()); len(names) > 0 {
+			errs = multierr.Append(errs, enc.AddArray("setCookies", stringArray(names)))
+		}
+	}
+	if r.options.responseEncoding {
+		if ce := r.Header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+			enc.AddString("responseEncoding", ce)
+		}
+	}
+	if r.options.redirectTo && r.Status >= http.StatusMultipleChoices && r.Status < http.StatusBadRequest {
+		if location := r.Header.Get("Location"); location != "" {
+			enc.AddString("redirectTo", r.options.maskLocation(location))
+		}
 	}
 
-	if extra, ok := (*r.Extra).(extraLogEntry); ok {
-		if len(extra.Body) != 0 {
-			enc.AddString("body", string(extra.Body))
+	if extra, ok := r.Extra.(extraLogEntry); ok {
+		if !r.omitBody {
+			errs = multierr.Append(errs, addBody(enc, r.options, "responseBody", &bodyLog{
+				content:     extra.Body,
+				truncated:   extra.BodyTruncated,
+				encoding:    r.Header.Get("Content-Encoding"),
+				contentType: r.Header.Get("Content-Type"),
+			}))
 		}
+		if extra.BodySkipped != "" {
+			enc.AddString("bodyLoggingSkipped", extra.BodySkipped)
+		}
+		if extra.RequestRead {
+			enc.AddDuration("requestReadDuration", extra.RequestReadDuration)
+		}
+	}
+	if r.options.grpcStatus {
+		if code, message, ok := grpcStatus(r.request, r.Header); ok {
+			enc.AddInt("grpcStatus", code)
+			if message != "" {
+				enc.AddString("grpcMessage", message)
+			}
+		}
+	}
+	// Tell a request no route matched from a 404 or 405 written by the application.
+	if r.Status == http.StatusNotFound || r.Status == http.StatusMethodNotAllowed {
+		if matched, allowed, ok := routeAllowedMethods(r.request); ok && !matched {
+			enc.AddBool("routeMatched", false)
+			if len(allowed) > 0 {
+				errs = multierr.Append(errs, enc.AddArray("allowedMethods", stringArray(allowed)))
+			}
+		}
+	}
+	if r.options.handlerName {
+		if name := handlerName(routeHandler(r.request)); name != "" {
+			enc.AddString("handler", name)
+		}
+	}
+	if r.options.routeMethods {
+		if methods := routeDeclaredMethods(r.request); len(methods) > 0 {
+			errs = multierr.Append(errs, enc.AddArray("routeMethods", stringArray(methods)))
+		}
+	}
+	if r.options.pathRules != nil {
+		enc.AddString("pathTemplate", pathTemplate(r.request, r.options.pathRules))
+	}
+	if r.options.serveMuxPattern && r.served != nil {
+		if pattern := requestPattern(r.served); pattern != "" {
+			enc.AddString("pattern", pattern)
+		}
+	}
+	return errs
+}
+
+// addElapsed adds the elapsed duration, along with the numeric fields of WithElapsedUnits.
+func (r *httpResponseLog) addElapsed(enc zapcore.ObjectEncoder) {
+	addDuration(enc, "elapsed", r.Elapsed, r.options.durationFormat)
+	for _, unit := range r.options.elapsedUnits {
+		switch unit {
+		case time.Second:
+			enc.AddFloat64("elapsedSeconds", r.Elapsed.Seconds())
+		case time.Millisecond:
+			enc.AddFloat64("elapsedMs", float64(r.Elapsed)/float64(time.Millisecond))
+		case time.Microsecond:
+			enc.AddInt64("elapsedUs", r.Elapsed.Microseconds())
+		case time.Nanosecond:
+			enc.AddInt64("elapsedNs", r.Elapsed.Nanoseconds())
+		}
+	}
+}
+
+// statusClass returns the class of status such as "2xx".
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// cookieNames returns the names of cookies. Values are never logged.
+func cookieNames(cookies []*http.Cookie) []string {
+	names := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+type stringArray []string
+
+// implement interface of zapcore.ArrayMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L38
+func (a stringArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, s := range a {
+		enc.AppendString(s)
 	}
 	return nil
 }
 
 type httpHeaderLog struct {
-	*http.Header
+	http.Header
+	options *options
 }
 
 // implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
 func (h *httpHeaderLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
-	maskedString := "***"
-	for k, v := range *h.Header {
+	enc = h.options.filterEncoder(enc)
+	for k, v := range h.Header {
 		k = strings.ToLower(k)
 		// values should be masked
-		if (k == "authorization" || k == "cookie" || k == "set-cookie") && len(v) != 0 {
-			enc.AddString(k, maskedString)
-			continue
+		if h.options.masking && len(v) != 0 {
+			if k == "authorization" && h.options.authorizationScheme {
+				// keep the scheme such as "Bearer" to debug scheme mismatches, but never the credentials
+				if scheme, _, ok := strings.Cut(v[0], " "); ok {
+					enc.AddString(k, scheme+" "+maskedString)
+					continue
+				}
+			}
+			if _, ok := h.options.maskHeaders[k]; ok {
+				enc.AddString(k, maskedString)
+				continue
+			}
+		}
+		if !h.options.masking && (k == "cookie" || k == "set-cookie") {
+			v = h.options.limitCookieHeader(k, v)
 		}
 		switch {
 		case len(v) == 0:
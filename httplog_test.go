@@ -0,0 +1,692 @@
+package httplog
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newObservedLogger returns a logger recording every log at level and above.
+func newObservedLogger(level zapcore.Level) (*zap.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(level)
+	return zap.New(snapshotCore{core}), logs
+}
+
+// snapshotCore marshals fields when they are added, as encoding cores do, rather than when
+// the test reads them, after the request is over.
+type snapshotCore struct {
+	zapcore.Core
+}
+
+func (c snapshotCore) With(fields []zapcore.Field) zapcore.Core {
+	return snapshotCore{c.Core.With(snapshot(fields))}
+}
+
+func (c snapshotCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c snapshotCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(e, snapshot(fields))
+}
+
+func snapshot(fields []zapcore.Field) []zapcore.Field {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	snapshot := make([]zapcore.Field, 0, len(enc.Fields))
+	for k, v := range enc.Fields {
+		snapshot = append(snapshot, zap.Any(k, v))
+	}
+	return snapshot
+}
+
+// serve runs r through the middleware built with opts around h.
+func serve(logger *zap.Logger, h http.Handler, r *http.Request, opts ...Option) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ZapRequestLogger(logger, opts...)(h).ServeHTTP(w, r)
+	return w
+}
+
+// loggedFields returns the fields of the only log with msg, nested objects decoded as maps.
+func loggedFields(t *testing.T, logs *observer.ObservedLogs, msg string) map[string]interface{} {
+	t.Helper()
+	entries := logs.FilterMessage(msg).All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d %q logs, want 1", len(entries), msg)
+	}
+	return entries[0].ContextMap()
+}
+
+// object returns the nested object key of fields.
+func object(t *testing.T, fields map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+	obj, ok := fields[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s = %#v, want an object", key, fields[key])
+	}
+	return obj
+}
+
+func writeBody(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestErrorLogger(t *testing.T) {
+	tests := []struct {
+		status  int
+		primary int
+		errors  int
+	}{
+		{http.StatusOK, 1, 0},
+		{http.StatusNotFound, 1, 0},
+		{http.StatusInternalServerError, 0, 1},
+		{http.StatusServiceUnavailable, 0, 1},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		errorLogger, errorLogs := newObservedLogger(zapcore.DebugLevel)
+		h := func(w http.ResponseWriter, r *http.Request) {
+			LogEntrySetField(r.Context(), "user", "alice")
+			w.WriteHeader(tt.status)
+		}
+		serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodGet, "/", nil), WithErrorLogger(errorLogger))
+		if got := logs.FilterMessage("Request complete").Len(); got != tt.primary {
+			t.Errorf("status %d: %d completion logs on the primary logger, want %d", tt.status, got, tt.primary)
+		}
+		if got := errorLogs.FilterMessage("Request complete").Len(); got != tt.errors {
+			t.Errorf("status %d: %d completion logs on the error logger, want %d", tt.status, got, tt.errors)
+		}
+		for _, e := range errorLogs.All() {
+			if e.ContextMap()["user"] != "alice" {
+				t.Errorf("status %d: error logger misses the fields set by the handler: %v", tt.status, e.ContextMap())
+			}
+		}
+	}
+}
+
+func TestEntryRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware func(logger *zap.Logger, opts ...Option) func(http.Handler) http.Handler
+	}{
+		{"ZapRequestLogger", ZapRequestLogger},
+		{"LogFormatter", func(logger *zap.Logger, opts ...Option) func(http.Handler) http.Handler {
+			return middleware.RequestLogger(LogFormatter(logger, opts...))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			var panicked *http.Request
+			finalize := WithFinalizeFields(func(r *http.Request, status, bytes int, elapsed time.Duration) []zap.Field {
+				return []zap.Field{zap.String("finalizedPath", r.URL.Path)}
+			})
+			hook := WithPanicHook(func(r *http.Request, v interface{}, stack []byte) {
+				panicked = r
+			})
+			h := tt.middleware(logger, finalize, hook)(middleware.Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			})))
+			r := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got := loggedFields(t, logs, "Request complete")["finalizedPath"]; got != "/items/1" {
+				t.Errorf("finalizedPath = %v, want /items/1", got)
+			}
+			if panicked == nil || panicked.URL.Path != "/items/1" {
+				t.Errorf("panic hook got request %v, want /items/1", panicked)
+			}
+		})
+	}
+}
+
+func TestLogEntrySetField(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(r *http.Request)
+		want map[string]interface{}
+	}{
+		{"field", func(r *http.Request) {
+			LogEntrySetField(r.Context(), "user", "alice")
+		}, map[string]interface{}{"user": "alice"}},
+		{"fields", func(r *http.Request) {
+			LogEntrySetFields(r.Context(), map[string]interface{}{"user": "alice", "tenant": "acme"})
+		}, map[string]interface{}{"user": "alice", "tenant": "acme"}},
+		{"after a handler log", func(r *http.Request) {
+			logger := LogEntry(r.Context())
+			logger.Info("handling")
+			LogEntrySetField(r.Context(), "user", "alice")
+		}, map[string]interface{}{"user": "alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				tt.set(r)
+				logger := LogEntry(r.Context())
+				logger.Info("handled")
+			}
+			serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodGet, "/", nil))
+			for _, msg := range []string{"Request complete", "handled"} {
+				fields := loggedFields(t, logs, msg)
+				for k, v := range tt.want {
+					if fields[k] != v {
+						t.Errorf("%s: %s = %v, want %v", msg, k, fields[k], v)
+					}
+				}
+			}
+			if _, ok := loggedFields(t, logs, "Request started")["user"]; ok {
+				t.Error("Request started carries a field set afterwards")
+			}
+		})
+	}
+}
+
+// flatEncoder fails to add objects nested in other objects, as some encoders do.
+type flatEncoder struct {
+	*zapcore.MapObjectEncoder
+	nested bool
+}
+
+func (e flatEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if e.nested {
+		return errors.New("nested objects are not supported")
+	}
+	inner := flatEncoder{MapObjectEncoder: zapcore.NewMapObjectEncoder(), nested: true}
+	err := obj.MarshalLogObject(inner)
+	e.Fields[key] = inner.Fields
+	return err
+}
+
+func TestMarshalerErrors(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "*/*")
+	o := newOptions(nil)
+	tests := []struct {
+		field zap.Field
+		key   string
+	}{
+		{zap.Object("httpRequest", &httpRequestLog{Request: r, options: o}), "httpRequestError"},
+		{zap.Object("httpResponse", &httpResponseLog{
+			Status: http.StatusOK, Header: http.Header{"Content-Type": {"text/plain"}}, Elapsed: time.Second, request: r, options: o,
+		}), "httpResponseError"},
+	}
+	for _, tt := range tests {
+		enc := flatEncoder{MapObjectEncoder: zapcore.NewMapObjectEncoder()}
+		tt.field.AddTo(enc)
+		if got, ok := enc.Fields[tt.key].(string); !ok || !strings.Contains(got, "nested objects are not supported") {
+			t.Errorf("%s = %v, want the encoder error", tt.key, enc.Fields[tt.key])
+		}
+	}
+}
+
+func TestEmptySuccessBodyCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		method  string
+		status  int
+		body    string
+		flagged bool
+		level   zapcore.Level
+	}{
+		{"empty 200", []Option{WithEmptySuccessBodyCheck(zapcore.WarnLevel)}, http.MethodGet, http.StatusOK, "", true, zapcore.WarnLevel},
+		{"empty 201", []Option{WithEmptySuccessBodyCheck(zapcore.WarnLevel)}, http.MethodPost, http.StatusCreated, "", true, zapcore.WarnLevel},
+		{"200 with body", []Option{WithEmptySuccessBodyCheck(zapcore.WarnLevel)}, http.MethodGet, http.StatusOK, "ok", false, zapcore.InfoLevel},
+		{"204", []Option{WithEmptySuccessBodyCheck(zapcore.WarnLevel)}, http.MethodDelete, http.StatusNoContent, "", false, zapcore.InfoLevel},
+		{"HEAD", []Option{WithEmptySuccessBodyCheck(zapcore.WarnLevel)}, http.MethodHead, http.StatusOK, "", false, zapcore.InfoLevel},
+		{"empty 404", []Option{WithEmptySuccessBodyCheck(zapcore.WarnLevel)}, http.MethodGet, http.StatusNotFound, "", false, zapcore.InfoLevel},
+		{"disabled", nil, http.MethodGet, http.StatusOK, "", false, zapcore.InfoLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			serve(logger, writeBody(tt.status, tt.body), httptest.NewRequest(tt.method, "/", nil), tt.opts...)
+			entries := logs.FilterMessage("Request complete").All()
+			if len(entries) != 1 {
+				t.Fatalf("got %d completion logs, want 1", len(entries))
+			}
+			if got := entries[0].ContextMap()["emptySuccessBody"] == true; got != tt.flagged {
+				t.Errorf("emptySuccessBody = %v, want %v", got, tt.flagged)
+			}
+			if entries[0].Level != tt.level {
+				t.Errorf("level = %v, want %v", entries[0].Level, tt.level)
+			}
+		})
+	}
+}
+
+func TestPanicID(t *testing.T) {
+	panics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	tests := []struct {
+		name    string
+		opts    []Option
+		handler http.Handler
+	}{
+		{"WithRecover", []Option{WithRecover(true)}, panics},
+		{"middleware.Recoverer", nil, middleware.Recoverer(panics)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			serve(logger, tt.handler, httptest.NewRequest(http.MethodGet, "/", nil), tt.opts...)
+			panicked := loggedFields(t, logs, "Panic")
+			completed := loggedFields(t, logs, "Request complete")
+			id, _ := panicked["panicId"].(string)
+			if id == "" {
+				t.Fatal("the panic log has no panicId")
+			}
+			if completed["panicId"] != id {
+				t.Errorf("completion panicId = %v, want %v", completed["panicId"], id)
+			}
+			if completed["panicked"] != true {
+				t.Errorf("completion panicked = %v, want true", completed["panicked"])
+			}
+		})
+	}
+}
+
+// customWriter is a ResponseWriter of its own type, which handlers may assert.
+type customWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func TestCompletionLogWriter(t *testing.T) {
+	tests := []struct {
+		completionLog bool
+		unwrapped     bool
+	}{
+		{true, false},
+		{false, true},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		var unwrapped bool
+		h := func(w http.ResponseWriter, r *http.Request) {
+			_, unwrapped = w.(customWriter)
+			LogEntrySetField(r.Context(), "user", "alice")
+		}
+		w := customWriter{httptest.NewRecorder()}
+		ZapRequestLogger(logger, WithCompletionLog(tt.completionLog))(http.HandlerFunc(h)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if unwrapped != tt.unwrapped {
+			t.Errorf("completion log %v: handler got the original writer %v, want %v", tt.completionLog, unwrapped, tt.unwrapped)
+		}
+		if got := logs.FilterMessage("Request started").Len(); got != 1 {
+			t.Errorf("completion log %v: %d start logs, want 1", tt.completionLog, got)
+		}
+		if got, want := logs.FilterMessage("Request complete").Len(), map[bool]int{true: 1, false: 0}[tt.completionLog]; got != want {
+			t.Errorf("completion log %v: %d completion logs, want %d", tt.completionLog, got, want)
+		}
+	}
+}
+
+func BenchmarkCompletionLog(b *testing.B) {
+	for _, enabled := range []bool{true, false} {
+		b.Run(map[bool]string{true: "wrapped", false: "unwrapped"}[enabled], func(b *testing.B) {
+			h := ZapRequestLogger(zap.NewNop(), WithCompletionLog(enabled))(writeBody(http.StatusOK, "ok"))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
+
+func TestShouldLog(t *testing.T) {
+	// Drop successful static responses only.
+	shouldLog := WithShouldLog(func(r *http.Request, status, bytes int, elapsed time.Duration) bool {
+		return !strings.HasPrefix(r.URL.Path, "/static/") || status >= http.StatusBadRequest
+	})
+	tests := []struct {
+		path   string
+		status int
+		logged bool
+	}{
+		{"/static/app.js", http.StatusOK, false},
+		{"/static/app.js", http.StatusNotFound, true},
+		{"/static/app.js", http.StatusInternalServerError, true},
+		{"/api/items", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		var got struct {
+			status, bytes int
+		}
+		record := WithFinalizeFields(func(r *http.Request, status, bytes int, elapsed time.Duration) []zap.Field {
+			got.status, got.bytes = status, bytes
+			return nil
+		})
+		serve(logger, writeBody(tt.status, "body"), httptest.NewRequest(http.MethodGet, tt.path, nil), shouldLog, record)
+		if n := logs.FilterMessage("Request complete").Len(); (n == 1) != tt.logged {
+			t.Errorf("%s %d: %d completion logs, want logged %v", tt.path, tt.status, n, tt.logged)
+		}
+		if n := logs.FilterMessage("Request started").Len(); n != 1 {
+			t.Errorf("%s %d: %d start logs, want 1", tt.path, tt.status, n)
+		}
+		if tt.logged && (got.status != tt.status || got.bytes != 4) {
+			t.Errorf("%s %d: completion of status %d with %d bytes, want %d with 4", tt.path, tt.status, got.status, got.bytes, tt.status)
+		}
+	}
+}
+
+func TestStatusImplicit(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		upgrade  bool
+		status   int
+		implicit interface{}
+	}{
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, false, http.StatusOK, true},
+		{"body only", writeBody(http.StatusOK, "ok"), false, http.StatusOK, nil},
+		{"explicit status", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }, false, http.StatusNoContent, nil},
+		{"upgrade", func(w http.ResponseWriter, r *http.Request) {}, true, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.upgrade {
+				r.Header.Set("Connection", "Upgrade")
+				r.Header.Set("Upgrade", "websocket")
+			}
+			serve(logger, tt.handler, r)
+			response := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")
+			if response["status"] != tt.status {
+				t.Errorf("status = %v, want %d", response["status"], tt.status)
+			}
+			if response["statusImplicit"] != tt.implicit {
+				t.Errorf("statusImplicit = %v, want %v", response["statusImplicit"], tt.implicit)
+			}
+		})
+	}
+}
+
+func TestPanicStack(t *testing.T) {
+	panics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	tests := []struct {
+		name    string
+		opts    []Option
+		handler http.Handler
+	}{
+		{"WithRecover", []Option{WithRecover(true)}, panics},
+		{"without WithRecover", nil, middleware.Recoverer(panics)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			// Loggers adding stack traces to errors must not add a second one to the panic log.
+			logger = logger.WithOptions(zap.AddStacktrace(zapcore.ErrorLevel))
+			w := serve(logger, tt.handler, httptest.NewRequest(http.MethodGet, "/", nil), tt.opts...)
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want 500", w.Code)
+			}
+			entries := logs.FilterMessage("Panic").All()
+			if len(entries) != 1 {
+				t.Fatalf("got %d panic logs, want 1", len(entries))
+			}
+			if entries[0].Stack != "" {
+				t.Errorf("the panic log has a zap stacktrace besides the stack field:\n%s", entries[0].Stack)
+			}
+			var stacks int
+			for _, f := range entries[0].Context {
+				if f.Key == "stack" {
+					stacks++
+					if !strings.Contains(f.String, "panic") {
+						t.Errorf("stack = %q, want the stack of the panic", f.String)
+					}
+				}
+			}
+			if stacks != 1 {
+				t.Errorf("got %d stack fields, want 1", stacks)
+			}
+			panicked := entries[0].ContextMap()
+			completed := loggedFields(t, logs, "Request complete")
+			if completed["panicked"] != true || completed["panicId"] != panicked["panicId"] {
+				t.Errorf("completion panicked = %v, panicId = %v, want true and %v", completed["panicked"], completed["panicId"], panicked["panicId"])
+			}
+		})
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	logger, logs := newObservedLogger(zapcore.DebugLevel)
+	h := ZapRequestLogger(logger, WithRecover(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if rvr := recover(); rvr != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed through", rvr)
+		}
+		if n := logs.FilterMessage("Panic").Len(); n != 0 {
+			t.Errorf("got %d panic logs for an aborted handler, want 0", n)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestRecoverWithoutCompletionLog(t *testing.T) {
+	logger, logs := newObservedLogger(zapcore.DebugLevel)
+	h := ZapRequestLogger(logger, WithRecover(true), WithCompletionLog(false))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	if got := loggedFields(t, logs, "Panic")["panic"]; got != "oops" {
+		t.Errorf("panic = %v, want oops", got)
+	}
+}
+
+func TestOnStart(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"start log", nil},
+		{"deferred start log", []Option{WithDeferredStartLog(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			onStart := WithOnStart(func(r *http.Request, log *zap.Logger) {
+				LogEntrySetField(r.Context(), "tenant", "t1")
+			})
+			h := func(w http.ResponseWriter, r *http.Request) {
+				entry := RawLogEntry(r.Context())
+				entry.Info("handler")
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodGet, "/", nil), append([]Option{onStart}, tt.opts...)...)
+			for _, msg := range []string{"Request started", "handler", "Request complete"} {
+				if got := loggedFields(t, logs, msg)["tenant"]; got != "t1" {
+					t.Errorf("%s: tenant = %v, want t1", msg, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStartLogOff(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// key is the object of the request fields in the handler logs.
+		key  string
+		want map[string]interface{}
+	}{
+		{"full request", nil, "httpRequest", map[string]interface{}{"method": "GET", "requestURI": "/items", "requestID": "req-1"}},
+		{"minimal request", []Option{WithMinimalRequestContext(true)}, "request", map[string]interface{}{"method": "GET", "path": "/items", "requestID": "req-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				entry := RawLogEntry(r.Context())
+				entry.Info("handler")
+			}
+			r := httptest.NewRequest(http.MethodGet, "/items", nil)
+			r.Header.Set("X-Request-Id", "req-1")
+			middleware.RequestID(ZapRequestLogger(logger, append([]Option{WithStartLog(false)}, tt.opts...)...)(http.HandlerFunc(h))).ServeHTTP(httptest.NewRecorder(), r)
+
+			if n := logs.FilterMessage("Request started").Len(); n != 0 {
+				t.Errorf("got %d start logs, want none", n)
+			}
+			loggedFields(t, logs, "Request complete")
+			request := object(t, loggedFields(t, logs, "handler"), tt.key)
+			for k, want := range tt.want {
+				if request[k] != want {
+					t.Errorf("handler log %s.%s = %v, want %v", tt.key, k, request[k], want)
+				}
+			}
+		})
+	}
+}
+
+func TestHeldBackStartLogBody(t *testing.T) {
+	minimal := WithMinimalRequestContext(true)
+	tests := []struct {
+		name string
+		opts []Option
+		// started tells whether the start log is written.
+		started bool
+	}{
+		{"deferred", []Option{minimal, WithDeferredStartLog(true)}, true},
+		{"start log off", []Option{minimal, WithStartLog(false)}, false},
+		{"opt-in", []Option{minimal, WithOptIn(true)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				LogEntryEnable(r.Context())
+				if b, _ := io.ReadAll(r.Body); string(b) != "request body" {
+					t.Errorf("handler read %q, want the whole body", b)
+				}
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("request body")), tt.opts...)
+
+			messages := []string{"Request complete"}
+			if tt.started {
+				messages = append(messages, "Request started")
+			} else if n := logs.FilterMessage("Request started").Len(); n != 0 {
+				t.Errorf("got %d start logs, want none", n)
+			}
+			for _, msg := range messages {
+				request := object(t, loggedFields(t, logs, msg), "httpRequest")
+				if request["body"] != "request body" || request["bodyUnavailable"] != nil {
+					t.Errorf("%s: body = %v, bodyUnavailable = %v, want the body", msg, request["body"], request["bodyUnavailable"])
+				}
+			}
+		})
+	}
+}
+
+func TestOptIn(t *testing.T) {
+	noCompletion := WithCompletionLog(false)
+	tests := []struct {
+		name   string
+		opts   []Option
+		enable bool
+		status int
+		panics bool
+		// want are the messages logged, in order.
+		want []string
+	}{
+		{"not enabled", nil, false, http.StatusOK, false, []string{"handler"}},
+		{"not enabled, client error", nil, false, http.StatusNotFound, false, []string{"handler"}},
+		{"enabled", nil, true, http.StatusOK, false, []string{"handler", "Request started", "Request complete"}},
+		{"server error", nil, false, http.StatusInternalServerError, false, []string{"handler", "Request started", "Request complete"}},
+		{"panic", []Option{WithRecover(true)}, false, http.StatusOK, true, []string{"handler", "Panic", "Request started", "Request complete"}},
+		{"deferred, enabled", []Option{WithDeferredStartLog(true)}, true, http.StatusOK, false, []string{"handler", "Request complete"}},
+		{"deferred, enabled, client error", []Option{WithDeferredStartLog(true)}, true, http.StatusNotFound, false, []string{"handler", "Request started", "Request complete"}},
+		{"deferred, server error", []Option{WithDeferredStartLog(true)}, false, http.StatusInternalServerError, false, []string{"handler", "Request started", "Request complete"}},
+		{"completion log off", []Option{noCompletion}, false, http.StatusOK, false, []string{"Request started", "handler"}},
+		{"completion log off, enabled", []Option{noCompletion}, true, http.StatusOK, false, []string{"Request started", "handler"}},
+		{"completion log off, deferred", []Option{noCompletion, WithDeferredStartLog(true)}, false, http.StatusNotFound, false, []string{"Request started", "handler"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				if tt.enable {
+					LogEntryEnable(r.Context())
+				}
+				entry := RawLogEntry(r.Context())
+				entry.Info("handler")
+				if tt.panics {
+					panic("oops")
+				}
+				w.WriteHeader(tt.status)
+			}
+			serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodGet, "/", nil), append([]Option{WithOptIn(true)}, tt.opts...)...)
+
+			var got []string
+			for _, e := range logs.All() {
+				got = append(got, e.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("logged %q, want %q", got, tt.want)
+			}
+			// A held back start log keeps the time the request arrived.
+			if started := logs.FilterMessage("Request started").All(); len(started) == 1 && started[0].Time.After(logs.All()[0].Time) {
+				t.Errorf("start log time %v is after the handler log at %v", started[0].Time, logs.All()[0].Time)
+			}
+		})
+	}
+}
+
+func BenchmarkZapRequestLogger(b *testing.B) {
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(io.Discard), zapcore.InfoLevel))
+	body := strings.Repeat("a", 1<<10)
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"body logging", nil},
+		{"tee capture", []Option{WithRequestBodyCaptureMode(BodyCaptureTee)}},
+		{"no body logging", []Option{WithBodyLogging(false)}},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			h := ZapRequestLogger(logger, tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(w, r.Body)
+			}))
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body))
+				w.Body.Reset()
+				h.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package httplog
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// sizeEncoder measures logs as they would be written by zap's production JSON encoder.
+var sizeEncoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+
+// encodedSize returns the size of a log with msg and fields encoded by sizeEncoder. It is measured at
+// the longest level name and the current time, whose encoding is longer than the zero time's.
+func encodedSize(msg string, fields []zap.Field) int {
+	buf, err := sizeEncoder.EncodeEntry(zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Now(), Message: msg}, fields)
+	if err != nil {
+		return 0
+	}
+	defer buf.Free()
+	return buf.Len()
+}
+
+// fitRequestLog drops the body, then the header, of r until the start log fits in max bytes.
+// It reports whether anything was dropped.
+func fitRequestLog(r *httpRequestLog, max int) bool {
+	fits := func() bool {
+		return encodedSize("Request started", []zap.Field{zap.Object("httpRequest", r)}) <= max
+	}
+	if fits() {
+		return false
+	}
+	r.omitBody = true
+	if !fits() {
+		r.omitHeader = true
+	}
+	return true
+}
+
+// fitLogSize drops the bodies, then the headers, of the request and the completion log fields until the log,
+// along with the fields of the entry logger, fits in max bytes, and marks the log with `truncatedForSize`.
+// The fields of logger were encoded when it was built, so when they hold the request, the log is written
+// by a logger rebuilt from base with the trimmed request instead, which fitLogSize returns.
+func (l *zapLogEntry) fitLogSize(max int, logger, base *zap.Logger, fields []zap.Field, response *httpResponseLog) (*zap.Logger, []zap.Field) {
+	l.mu.Lock()
+	added := l.added
+	l.mu.Unlock()
+	loggerFields := l.fields
+	fits := func() bool {
+		all := make([]zap.Field, 0, len(loggerFields)+len(added)+len(fields))
+		all = append(append(append(all, loggerFields...), added...), fields...)
+		return encodedSize("Request complete", all) <= max
+	}
+	truncated := l.truncatedForSize
+	if !fits() {
+		truncated = true
+		// The request log may still be marshaled by other logs, so trim a copy.
+		request := *l.requestLog
+		request.omitBody = true
+		response.omitBody = true
+		fields, _ = trimRequest(fields, l.requestLog, &request)
+		var inLogger bool
+		if loggerFields, inLogger = trimRequest(l.fields, l.requestLog, &request); inLogger {
+			logger = base.With(loggerFields...).With(added...)
+		}
+		if !fits() {
+			request.omitHeader = true
+			response.omitHeader = true
+		}
+	}
+	if truncated {
+		fields = append(fields, zap.Bool("truncatedForSize", true))
+	}
+	return logger, fields
+}
+
+// trimRequest returns fields without the captured request body, and with request in place of the
+// object of original. replaced reports whether fields held that object.
+func trimRequest(fields []zap.Field, original, request *httpRequestLog) (trimmed []zap.Field, replaced bool) {
+	trimmed = make([]zap.Field, 0, len(fields))
+	for _, f := range fields {
+		switch {
+		case f.Key == "requestBody" || f.Key == "requestBodyTruncated":
+			continue
+		case f.Type == zapcore.ObjectMarshalerType && f.Interface == original:
+			f = zap.Object(f.Key, request)
+			replaced = true
+		}
+		trimmed = append(trimmed, f)
+	}
+	return trimmed, replaced
+}
@@ -0,0 +1,108 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMaxLogSize(t *testing.T) {
+	large := strings.Repeat("a", 2000)
+	tests := []struct {
+		name         string
+		requestBody  string
+		responseBody string
+		header       string
+		// started and completed tell whether each log is truncated.
+		started, completed bool
+		// The bodies and headers logged after truncation.
+		requestBodyLogged, responseBodyLogged, headersLogged bool
+	}{
+		{"fits", "small", "small", "small", false, false, true, true, true},
+		{"large request body", large, "small", "small", true, true, false, true, true},
+		{"large response body", "small", large, "small", false, true, false, false, true},
+		{"large header", "small", "small", large, true, true, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Large", tt.header)
+				w.Write([]byte(tt.responseBody))
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.requestBody))
+			r.Header.Set("X-Large", tt.header)
+			serve(logger, http.HandlerFunc(h), r, WithMaxLogSize(1500))
+
+			started, completed := loggedFields(t, logs, "Request started"), loggedFields(t, logs, "Request complete")
+			if got := started["truncatedForSize"] == true; got != tt.started {
+				t.Errorf("start log truncatedForSize = %v, want %v", got, tt.started)
+			}
+			if got := completed["truncatedForSize"] == true; got != tt.completed {
+				t.Errorf("completion log truncatedForSize = %v, want %v", got, tt.completed)
+			}
+			request, response := object(t, completed, "httpRequest"), object(t, completed, "httpResponse")
+			if _, ok := request["body"]; ok != tt.requestBodyLogged {
+				t.Errorf("request body logged %v, want %v", ok, tt.requestBodyLogged)
+			}
+			if _, ok := response["body"]; ok != tt.responseBodyLogged {
+				t.Errorf("response body logged %v, want %v", ok, tt.responseBodyLogged)
+			}
+			if _, ok := response["header"]; ok != tt.headersLogged {
+				t.Errorf("response header logged %v, want %v", ok, tt.headersLogged)
+			}
+			if _, ok := object(t, started, "httpRequest")["header"]; ok != tt.headersLogged {
+				t.Errorf("request header logged %v, want %v", ok, tt.headersLogged)
+			}
+		})
+	}
+}
+
+func TestMaxLogSizeLine(t *testing.T) {
+	const max = 1200
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"request in the logger fields", nil},
+		{"minimal request context", []Option{WithMinimalRequestContext(true)}},
+		{"tee capture", []Option{WithRequestBodyCaptureMode(BodyCaptureTee)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&out), zapcore.DebugLevel))
+			h := func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				LogEntrySetField(r.Context(), "tenant", "t1")
+				w.Write([]byte("response"))
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 900)))
+			serve(logger, http.HandlerFunc(h), r, append([]Option{WithMaxLogSize(max)}, tt.opts...)...)
+
+			var completed map[string]interface{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if len(line) > max {
+					t.Errorf("%d bytes logged, want at most %d: %s", len(line), max, line)
+				}
+				var log map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &log); err != nil {
+					t.Fatal(err)
+				}
+				if log["msg"] == "Request complete" {
+					completed = log
+				}
+			}
+			if completed["truncatedForSize"] != true || completed["tenant"] != "t1" {
+				t.Errorf("completion log = %v, want it truncated with the tenant field", completed)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package httplog
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+const maskedString = "***"
+
+type bodyType struct {
+	pattern string
+	typ     reflect.Type
+}
+
+// maskBody redacts a JSON request body through the type registered for the request path with
+// WithMaskedBodyType, or else the keys of WithMaskJSONKeys. ok is false when neither applies or the
+// body is not JSON. A body which cannot be decoded is dropped rather than logged raw.
+func maskBody(o *options, r *http.Request, body []byte) (masked []byte, ok bool) {
+	if !o.masking || len(o.bodyTypes) == 0 && len(o.maskJSONKeys) == 0 || len(body) == 0 {
+		return nil, false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return nil, false
+	}
+	for _, bt := range o.bodyTypes {
+		if !matchAnyPath([]string{bt.pattern}, r.URL.Path) {
+			continue
+		}
+		masked, err := maskTypedJSON(body, bt.typ)
+		if err != nil {
+			return nil, true
+		}
+		return masked, true
+	}
+	if len(o.maskJSONKeys) == 0 {
+		return nil, false
+	}
+	masked, err := MaskJSON(body, o.maskJSONKeys)
+	if err != nil {
+		return nil, true
+	}
+	return masked, true
+}
+
+// MaskTypedJSON decodes the JSON body into a new value of the prototype's type, masks the fields
+// tagged `log:"mask"` as MaskFields does and encodes it again. Fields missing from the type are dropped.
+// It is what the middleware applies to the bodies registered with WithMaskedBodyType.
+func MaskTypedJSON(body []byte, prototype interface{}) ([]byte, error) {
+	t := reflect.TypeOf(prototype)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil, errors.New("httplog: nil prototype")
+	}
+	return maskTypedJSON(body, t)
+}
+
+func maskTypedJSON(body []byte, t reflect.Type) ([]byte, error) {
+	v := reflect.New(t)
+	if err := json.Unmarshal(body, v.Interface()); err != nil {
+		return nil, err
+	}
+	maskValue(v.Elem())
+	return json.Marshal(v.Interface())
+}
+
+// MaskFields masks in place the fields tagged `log:"mask"` in the value v points to: strings become "***",
+// other values are zeroed. Nested structs, pointers, slices and maps are walked.
+func MaskFields(v interface{}) {
+	maskValue(reflect.ValueOf(v))
+}
+
+// MaskJSON replaces with "***" the values of the object members of the JSON body whose name is one
+// of keys, at any depth. Object members are re-encoded in key order. An error is returned when the
+// body is not valid JSON. It is what the middleware applies with WithMaskJSONKeys.
+func MaskJSON(body []byte, keys []string) ([]byte, error) {
+	v, err := ParseJSONBody(body)
+	if err != nil {
+		return nil, err
+	}
+	masked := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		masked[k] = struct{}{}
+	}
+	return json.Marshal(maskJSONValue(v, masked))
+}
+
+func maskJSONValue(v interface{}, keys map[string]struct{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if _, ok := keys[k]; ok {
+				v[k] = maskedString
+			} else {
+				v[k] = maskJSONValue(e, keys)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = maskJSONValue(e, keys)
+		}
+	}
+	return v
+}
+
+// maskValue replaces the fields tagged `log:"mask"` in v: strings become "***",
+// other values are zeroed. Nested structs, pointers, slices and maps are walked.
+func maskValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			maskValue(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			maskValue(v.Index(i))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			maskValue(e)
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if !f.CanSet() {
+				continue
+			}
+			if t.Field(i).Tag.Get("log") != "mask" {
+				maskValue(f)
+				continue
+			}
+			if f.Kind() == reflect.String {
+				f.SetString(maskedString)
+			} else {
+				f.Set(reflect.Zero(f.Type()))
+			}
+		}
+	}
+}
+
+// requestURI returns the RequestURI of r with the values of the query parameters of WithMaskQueryParams masked.
+func (o *options) requestURI(r *http.Request) string {
+	if !o.masking || len(o.maskQueryParams) == 0 {
+		return r.RequestURI
+	}
+	i := strings.IndexByte(r.RequestURI, '?')
+	if i < 0 {
+		return r.RequestURI
+	}
+	return r.RequestURI[:i+1] + o.maskQuery(r.RequestURI[i+1:])
+}
+
+// maskLocation masks the query parameters of WithMaskQueryParams in a Location header value.
+func (o *options) maskLocation(location string) string {
+	i := strings.IndexByte(location, '?')
+	if i < 0 {
+		return location
+	}
+	query, fragment := location[i+1:], ""
+	if j := strings.IndexByte(query, '#'); j >= 0 {
+		query, fragment = query[:j], query[j:]
+	}
+	return location[:i+1] + o.maskQuery(query) + fragment
+}
+
+// maskQuery masks the values of the parameters of WithMaskQueryParams in the raw query, keeping it as written otherwise.
+func (o *options) maskQuery(query string) string {
+	if !o.masking || len(o.maskQueryParams) == 0 || query == "" {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name := param
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			name = param[:j]
+		}
+		key := name
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			key = unescaped
+		}
+		if _, ok := o.maskQueryParams[strings.ToLower(key)]; ok {
+			params[i] = name + "=" + maskedString
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// limitCookieHeader returns the values of a Cookie or Set-Cookie header cut to WithUnmaskedCookieLimits,
+// in a new slice so that the header itself is not modified.
+func (o *options) limitCookieHeader(key string, values []string) []string {
+	if o.maxCookieHeaderBytes <= 0 && o.maxCookieValueBytes <= 0 {
+		return values
+	}
+	limited := make([]string, len(values))
+	for i, v := range values {
+		if n := o.maxCookieValueBytes; n > 0 {
+			if key == "cookie" {
+				cookies := strings.Split(v, ";")
+				for j, c := range cookies {
+					cookies[j] = limitCookieValue(c, n)
+				}
+				v = strings.Join(cookies, ";")
+			} else if cookie, attrs, ok := strings.Cut(v, ";"); ok {
+				v = limitCookieValue(cookie, n) + ";" + attrs
+			} else {
+				v = limitCookieValue(v, n)
+			}
+		}
+		if n := o.maxCookieHeaderBytes; n > 0 && len(v) > n {
+			v = v[:n] + "..."
+		}
+		limited[i] = v
+	}
+	return limited
+}
+
+// limitCookieValue cuts the value of the "name=value" cookie to n bytes.
+func limitCookieValue(cookie string, n int) string {
+	name, value, ok := strings.Cut(cookie, "=")
+	if !ok || len(value) <= n {
+		return cookie
+	}
+	return name + "=" + value[:n] + "..."
+}
+
+// authorizationMasked reports whether the Authorization header is masked, in which case nothing taken
+// from it, such as the user of Basic authentication, is logged either.
+func (o *options) authorizationMasked() bool {
+	_, ok := o.maskHeaders["authorization"]
+	return o.masking && ok
+}
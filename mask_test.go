@@ -0,0 +1,211 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type maskedLogin struct {
+	User     string `json:"user"`
+	Password string `json:"password" log:"mask"`
+}
+
+func TestRequestBodyMasking(t *testing.T) {
+	typed := WithMaskedBodyType("/login", maskedLogin{})
+	keys := WithMaskJSONKeys("password", "token")
+	tests := []struct {
+		name        string
+		opts        []Option
+		path        string
+		contentType string
+		body        string
+		want        interface{}
+	}{
+		{"registered type", []Option{typed, keys}, "/login", "application/json",
+			`{"user":"alice","password":"secret","extra":1}`, `{"user":"alice","password":"***"}`},
+		{"keys without registered type", []Option{typed, keys}, "/signup", "application/json",
+			`{"user":"alice","password":"secret","profile":{"token":"t"}}`, `{"password":"***","profile":{"token":"***"},"user":"alice"}`},
+		{"keys on invalid JSON", []Option{keys}, "/signup", "application/json", `{"password":`, nil},
+		{"keys on another content type", []Option{keys}, "/signup", "text/plain", `password=secret`, `password=secret`},
+		{"no type nor keys", []Option{typed}, "/signup", "application/json", `{"password":"secret"}`, `{"password":"secret"}`},
+		{"masking off", []Option{keys, WithMasking(false)}, "/signup", "application/json", `{"password":"secret"}`, `{"password":"secret"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			serve(logger, writeBody(http.StatusOK, ""), r, tt.opts...)
+			if got := object(t, loggedFields(t, logs, "Request started"), "httpRequest")["body"]; got != tt.want {
+				t.Errorf("body = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskJSON(t *testing.T) {
+	keys := []string{"password", "token"}
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"top level", `{"user":"alice","password":"secret"}`, `{"password":"***","user":"alice"}`, false},
+		{"nested", `{"auth":{"token":"t","scope":"read"}}`, `{"auth":{"scope":"read","token":"***"}}`, false},
+		{"object value", `{"token":{"value":"t"}}`, `{"token":"***"}`, false},
+		{"array of objects", `[{"token":"a"},{"token":"b","id":1}]`, `[{"token":"***"},{"id":1,"token":"***"}]`, false},
+		{"array in object", `{"sessions":[{"token":"a"}],"tags":["token"]}`, `{"sessions":[{"token":"***"}],"tags":["token"]}`, false},
+		{"key case", `{"Password":"secret"}`, `{"Password":"secret"}`, false},
+		{"numbers kept as written", `{"id":12345678901234567890,"ratio":1.50}`, `{"id":12345678901234567890,"ratio":1.50}`, false},
+		{"scalar", `"password"`, `"password"`, false},
+		{"surrounding whitespace", " {\"token\":\"t\"}\n", `{"token":"***"}`, false},
+		{"invalid", `{"password":`, "", true},
+		{"empty", ``, "", true},
+		{"trailing data", `{"token":"t"} {"token":"u"}`, "", true},
+		{"trailing garbage", `{"token":"t"}x`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MaskJSON([]byte(tt.body), keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MaskJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskTypedJSON(t *testing.T) {
+	type profile struct {
+		Name  string `json:"name"`
+		Phone string `json:"phone" log:"mask"`
+	}
+	type account struct {
+		ID       int       `json:"id"`
+		PIN      int       `json:"pin" log:"mask"`
+		Profile  *profile  `json:"profile"`
+		Contacts []profile `json:"contacts"`
+	}
+	tests := []struct {
+		name      string
+		prototype interface{}
+		body      string
+		want      string
+		wantErr   bool
+	}{
+		{"struct", maskedLogin{}, `{"user":"alice","password":"secret"}`, `{"user":"alice","password":"***"}`, false},
+		{"pointer prototype", &maskedLogin{}, `{"password":"secret"}`, `{"user":"","password":"***"}`, false},
+		{"unknown fields dropped", maskedLogin{}, `{"user":"alice","token":"t"}`, `{"user":"alice","password":"***"}`, false},
+		{"nested and zeroed", account{}, `{"id":1,"pin":1234,"profile":{"name":"a","phone":"1"},"contacts":[{"name":"b","phone":"2"}]}`,
+			`{"id":1,"pin":0,"profile":{"name":"a","phone":"***"},"contacts":[{"name":"b","phone":"***"}]}`, false},
+		{"invalid", maskedLogin{}, `{"user":`, "", true},
+		{"mismatched type", maskedLogin{}, `{"user":1}`, "", true},
+		{"nil prototype", nil, `{}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MaskTypedJSON([]byte(tt.body), tt.prototype)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MaskTypedJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskFields(t *testing.T) {
+	type inner struct {
+		Secret string `log:"mask"`
+		Public string
+	}
+	type outer struct {
+		Secret string `log:"mask"`
+		Count  int    `log:"mask"`
+		Inner  inner
+		Ptr    *inner
+		Slice  []inner
+		Map    map[string]inner
+		hidden string `log:"mask"`
+	}
+	v := outer{
+		Secret: "s", Count: 3,
+		Inner:  inner{Secret: "s", Public: "p"},
+		Ptr:    &inner{Secret: "s", Public: "p"},
+		Slice:  []inner{{Secret: "s", Public: "p"}},
+		Map:    map[string]inner{"k": {Secret: "s", Public: "p"}},
+		hidden: "h",
+	}
+	MaskFields(&v)
+	masked := inner{Secret: maskedString, Public: "p"}
+	if v.Secret != maskedString || v.Count != 0 {
+		t.Errorf("top level fields = %q, %d, want masked", v.Secret, v.Count)
+	}
+	if v.Inner != masked || *v.Ptr != masked || v.Slice[0] != masked || v.Map["k"] != masked {
+		t.Errorf("nested fields = %+v, %+v, %+v, %+v, want %+v", v.Inner, *v.Ptr, v.Slice[0], v.Map["k"], masked)
+	}
+	if v.hidden != "h" {
+		t.Errorf("unexported field = %q, want it untouched", v.hidden)
+	}
+}
+
+func TestMaskQueryParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		target string
+		want   string
+	}{
+		{"masked", nil, "/cb?code=abc&state=xyz", "/cb?code=***&state=xyz"},
+		{"case-insensitive", nil, "/cb?Access_Token=abc", "/cb?Access_Token=***"},
+		{"escaped name", nil, "/cb?access%5Ftoken=abc", "/cb?access%5Ftoken=***"},
+		{"repeated", nil, "/cb?code=a&code=b", "/cb?code=***&code=***"},
+		{"without value", nil, "/cb?code", "/cb?code=***"},
+		{"no query", nil, "/cb", "/cb"},
+		{"other params", nil, "/cb?page=2", "/cb?page=2"},
+		{"masking off", []Option{WithMasking(false)}, "/cb?code=abc", "/cb?code=abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			opts := append([]Option{WithMaskQueryParams("code", "access_token"), WithCombinedLog(true)}, tt.opts...)
+			serve(logger, writeBody(http.StatusOK, ""), httptest.NewRequest(http.MethodGet, tt.target, nil), opts...)
+			completed := loggedFields(t, logs, "Request complete")
+			if got := object(t, completed, "httpRequest")["requestURI"]; got != tt.want {
+				t.Errorf("requestURI = %v, want %v", got, tt.want)
+			}
+			if got, _ := completed["accessLog"].(string); !strings.Contains(got, `"GET `+tt.want+` HTTP/1.1"`) {
+				t.Errorf("accessLog = %v, want the request line with %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"/login?code=abc#top", "/login?code=***#top"},
+		{"https://example.com/?state=1&code=abc", "https://example.com/?state=1&code=***"},
+		{"/plain", "/plain"},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, tt.location, http.StatusFound)
+		}
+		serve(logger, http.HandlerFunc(h), httptest.NewRequest(http.MethodGet, "/", nil), WithMaskQueryParams("code"), WithRedirectTo(true))
+		if got := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")["redirectTo"]; got != tt.want {
+			t.Errorf("redirectTo = %v, want %v", got, tt.want)
+		}
+	}
+}
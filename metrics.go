@@ -0,0 +1,37 @@
+package httplog
+
+import "net/http"
+
+// Metrics records the body sizes of completed requests, see WithMetrics. Implement it with the metrics
+// library of choice to get payload size histograms, e.g. with Prometheus:
+//
+//	type sizeMetrics struct {
+//		request, response *prometheus.HistogramVec
+//	}
+//
+//	func (m sizeMetrics) ObserveRequestSize(method, route string, bytes int64) {
+//		m.request.WithLabelValues(method, route).Observe(float64(bytes))
+//	}
+//
+//	func (m sizeMetrics) ObserveResponseSize(method, route string, bytes int64) {
+//		m.response.WithLabelValues(method, route).Observe(float64(bytes))
+//	}
+//
+// The methods are called concurrently.
+type Metrics interface {
+	// ObserveRequestSize records how many bytes of the request body were read, by the handler or for logging.
+	ObserveRequestSize(method, route string, bytes int64)
+	// ObserveResponseSize records how many bytes of the response body were written.
+	ObserveResponseSize(method, route string, bytes int64)
+}
+
+// observeSizes records the body sizes of r to m, labeled by method and route. The route is the chi route
+// pattern, or "unmatched" when chi did not route the request, so that raw paths never become labels.
+func observeSizes(m Metrics, r *http.Request, requestBytes int64, bytes int) {
+	route := routePattern(r)
+	if route == "" {
+		route = "unmatched"
+	}
+	m.ObserveRequestSize(r.Method, route, requestBytes)
+	m.ObserveResponseSize(r.Method, route, int64(bytes))
+}
@@ -0,0 +1,108 @@
+package httplog
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// testRegistry records the observations of histograms keyed by name and labels.
+type testRegistry struct {
+	mu           sync.Mutex
+	observations map[string][]int64
+}
+
+func (r *testRegistry) observe(name, method, route string, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.observations == nil {
+		r.observations = make(map[string][]int64)
+	}
+	key := fmt.Sprintf("%s{method=%s,route=%s}", name, method, route)
+	r.observations[key] = append(r.observations[key], bytes)
+}
+
+func (r *testRegistry) ObserveRequestSize(method, route string, bytes int64) {
+	r.observe("request_size", method, route, bytes)
+}
+
+func (r *testRegistry) ObserveResponseSize(method, route string, bytes int64) {
+	r.observe("response_size", method, route, bytes)
+}
+
+func TestMetrics(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}
+	// A Debug logger reads the request body for logging, whether or not the handler does.
+	debugLogger, _ := newObservedLogger(zapcore.DebugLevel)
+	tests := []struct {
+		name    string
+		logger  *zap.Logger
+		opts    []Option
+		routed  bool
+		handler http.HandlerFunc
+		want    map[string][]int64
+	}{
+		{"routed", zap.NewNop(), nil, true, echo, map[string][]int64{
+			"request_size{method=POST,route=/items/{id}}":  {4},
+			"response_size{method=POST,route=/items/{id}}": {4},
+		}},
+		{"unread body", zap.NewNop(), nil, true, writeBody(http.StatusOK, "ok"), map[string][]int64{
+			"request_size{method=POST,route=/items/{id}}":  {0},
+			"response_size{method=POST,route=/items/{id}}": {2},
+		}},
+		{"unmatched", zap.NewNop(), nil, false, echo, map[string][]int64{
+			"request_size{method=POST,route=unmatched}":  {4},
+			"response_size{method=POST,route=unmatched}": {4},
+		}},
+		{"body read for logging", debugLogger, nil, true, writeBody(http.StatusOK, "ok"), map[string][]int64{
+			"request_size{method=POST,route=/items/{id}}":  {4},
+			"response_size{method=POST,route=/items/{id}}": {2},
+		}},
+		{"completion log off", zap.NewNop(), []Option{WithCompletionLog(false)}, true, echo, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := &testRegistry{}
+			logging := ZapRequestLogger(tt.logger, append([]Option{WithMetrics(registry)}, tt.opts...)...)
+			h := logging(tt.handler)
+			if tt.routed {
+				router := chi.NewRouter()
+				router.Use(logging)
+				router.Post("/items/{id}", tt.handler)
+				h = router
+			}
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/items/1", strings.NewReader("body")))
+			if !reflect.DeepEqual(registry.observations, tt.want) {
+				t.Errorf("observations = %v, want %v", registry.observations, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsConcurrent(t *testing.T) {
+	registry := &testRegistry{}
+	h := ZapRequestLogger(zap.NewNop(), WithMetrics(registry))(writeBody(http.StatusOK, "ok"))
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	wg.Wait()
+	if got := len(registry.observations["response_size{method=GET,route=unmatched}"]); got != 50 {
+		t.Errorf("got %d response size observations, want 50", got)
+	}
+}
@@ -0,0 +1,71 @@
+package httplog
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+type multipartPart struct {
+	Name        string
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+type multipartLog struct {
+	Parts []multipartPart
+}
+
+// parseMultipart summarizes the parts of a multipart/form-data body without keeping their contents.
+// ok is false when contentType is not multipart/form-data.
+func parseMultipart(contentType string, body []byte) (log *multipartLog, ok bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, false
+	}
+	log = &multipartLog{}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			// Either the end of the body or the logged body was cut short; keep what was read.
+			break
+		}
+		size, _ := io.Copy(io.Discard, part)
+		log.Parts = append(log.Parts, multipartPart{
+			Name:        part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        size,
+		})
+		part.Close()
+	}
+	return log, true
+}
+
+// implement interface of zapcore.ArrayMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L38
+func (m *multipartLog) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	var errs error
+	for i := range m.Parts {
+		errs = multierr.Append(errs, enc.AppendObject(&m.Parts[i]))
+	}
+	return errs
+}
+
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (p *multipartPart) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", p.Name)
+	if p.Filename != "" {
+		enc.AddString("filename", p.Filename)
+	}
+	if p.ContentType != "" {
+		enc.AddString("contentType", p.ContentType)
+	}
+	enc.AddInt64("size", p.Size)
+	return nil
+}
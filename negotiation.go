@@ -0,0 +1,90 @@
+package httplog
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// acceptHeaders are the content negotiation headers logged with WithAcceptHeaders, by field name.
+var acceptHeaders = []struct {
+	header string
+	key    string
+}{
+	{"Accept", "accept"},
+	{"Accept-Encoding", "acceptEncoding"},
+	{"Accept-Language", "acceptLanguage"},
+}
+
+// acceptFields returns the content negotiation headers of the request as fields, omitting absent ones.
+func acceptFields(header http.Header, quality bool) []zap.Field {
+	var fields []zap.Field
+	for _, h := range acceptHeaders {
+		if values := parseAccept(header.Values(h.header)); len(values) > 0 {
+			fields = append(fields, zap.Array(h.key, &acceptLog{values: values, quality: quality}))
+		}
+	}
+	return fields
+}
+
+type acceptValue struct {
+	value string
+	q     float64
+}
+
+// parseAccept parses the comma separated values of Accept style headers, along with their quality values.
+// Values without a valid q parameter have a quality of 1; other parameters are kept with the value.
+func parseAccept(lines []string) []acceptValue {
+	var values []acceptValue
+	for _, line := range lines {
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			v := acceptValue{value: item, q: 1}
+			params := strings.Split(item, ";")
+			kept := params[:1]
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if name, value, ok := strings.Cut(param, "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+					if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+						v.q = q
+					}
+					continue
+				}
+				kept = append(kept, param)
+			}
+			v.value = strings.TrimSpace(strings.Join(kept, ";"))
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+type acceptLog struct {
+	values  []acceptValue
+	quality bool
+}
+
+// implement interface of zapcore.ArrayMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L38
+func (a *acceptLog) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, v := range a.values {
+		if !a.quality {
+			enc.AppendString(v.value)
+			continue
+		}
+		v := v
+		if err := enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("value", v.value)
+			enc.AddFloat64("q", v.q)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
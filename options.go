@@ -0,0 +1,1157 @@
+package httplog
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Option configures the middleware returned by ZapRequestLogger.
+type Option func(*options)
+
+// DefaultMaxBodyBytes is the default upper bound of request and response body bytes read for logging.
+const DefaultMaxBodyBytes = 64 << 10
+
+// BodyCaptureMode is when and how the request body is captured for logging.
+type BodyCaptureMode int
+
+const (
+	// BodyCaptureEager reads the request body before the handler runs and logs it on
+	// the start log. The handler receives a restored copy of the body.
+	BodyCaptureEager BodyCaptureMode = iota
+	// BodyCaptureTee records the request body while the handler reads it and logs it as
+	// `requestBody` on the completion log. Exactly what the handler read is logged,
+	// so nothing is logged when the handler does not read the body.
+	BodyCaptureTee
+	// BodyCaptureTeeOnError is like BodyCaptureTee, but the request body is logged only when
+	// the response status is 400 or above. This keeps the cost of the success path low while
+	// preserving the body for investigating failures.
+	BodyCaptureTeeOnError
+)
+
+type options struct {
+	config      *Config
+	errorLogger *zap.Logger
+	skippers    []func(r *http.Request) bool
+
+	staticFields      []zap.Field
+	completionLog     bool
+	startLog          bool
+	deferStartLog     bool
+	optIn             bool
+	privateContextKey bool
+	fieldFilter       func(key string) bool
+	eventChannel      chan<- RequestEvent
+	metrics           Metrics
+
+	// body logging
+	restoreRequestBody       bool
+	bodySampleRate           float64
+	debugSampleRate          float64
+	responseBodyStatuses     map[int]struct{}
+	responseBodyPredicate    func(contentType string, body []byte) bool
+	multipartSummary         bool
+	bodyExcludePaths         []string
+	nestedBody               bool
+	bodyLogger               *zap.Logger
+	bodyParsers              map[string]BodyParser
+	charsetDecoding          bool
+	bodyLines                int
+	charsetDecoders          map[string]CharsetDecoder
+	streamingTypes           map[string]struct{}
+	streamingInterval        time.Duration
+	maxResponseContentLength int64
+
+	requestBodyCaptureMode BodyCaptureMode
+	maxLogSize             int
+
+	// masking
+	masking              bool
+	maskHeaders          map[string]struct{}
+	maskQueryParams      map[string]struct{}
+	maskAuthSubject      bool
+	authorizationScheme  bool
+	bodyTypes            []bodyType
+	maskJSONKeys         []string
+	cookieNames          bool
+	maxCookieHeaderBytes int
+	maxCookieValueBytes  int
+
+	// optional fields
+	requestHeaders       bool
+	responseHeaders      bool
+	requestContentType   bool
+	handlerName          bool
+	routeMethods         bool
+	handlerLogCount      bool
+	routeNameKey         interface{}
+	requestIDExtractor   func(r *http.Request) string
+	serveMuxPattern      bool
+	pathRules            []PathRule
+	responseEncoding     bool
+	redirectTo           bool
+	combinedLog          bool
+	clientCert           bool
+	localAddr            bool
+	protoVersion         bool
+	responseHeaderFields []headerField
+	statusClass          bool
+	elapsedUnits         []time.Duration
+	durationFormat       DurationFormat
+	trustProxy           bool
+	baggageKeys          []string
+	requestReadDuration  bool
+	queueTimeHeader      string
+	requestTimeLayout    string
+	serverUptime         bool
+	acceptHeaders        bool
+	acceptQuality        bool
+	retryHeader          string
+	retryParser          func(value string) (retry bool, count int)
+	bytesTotal           bool
+	grpcStatus           bool
+	emptySuccessBody     *zapcore.Level
+
+	minimalRequestContext bool
+	adaptiveVerbosity     bool
+	memStats              *memStatsCache
+
+	spanID          bool
+	spanIDKey       string
+	parentSpanIDKey string
+
+	schema           Schema
+	coalescer        *Coalescer
+	routeSampler     *routeSampler
+	statusClassRates map[string]float64
+
+	// hooks
+	finalizeFields func(r *http.Request, status, bytes int, elapsed time.Duration) []zap.Field
+	shouldLog      func(r *http.Request, status, bytes int, elapsed time.Duration) bool
+	panicHook      func(r *http.Request, v interface{}, stack []byte)
+	onStart        func(r *http.Request, log *zap.Logger)
+	recoverPanics  bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		config:               newConfig(),
+		maskHeaders:          map[string]struct{}{"authorization": {}, "cookie": {}, "set-cookie": {}},
+		restoreRequestBody:   true,
+		completionLog:        true,
+		requestHeaders:       true,
+		responseHeaders:      true,
+		requestContentType:   true,
+		masking:              true,
+		maxCookieHeaderBytes: defaultMaxCookieHeaderBytes,
+		startLog:             true,
+		bodySampleRate:       1,
+		spanIDKey:            "spanId",
+		parentSpanIDKey:      "parentSpanId",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// skip reports whether the request should bypass the middleware entirely.
+func (o *options) skip(r *http.Request) bool {
+	for _, fn := range o.skippers {
+		if fn(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyPath reports whether p matches any of patterns. A pattern ending with "/*" matches the
+// prefix and everything below it, other patterns are matched with path.Match.
+func matchAnyPath(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if prefix := strings.TrimSuffix(pattern, "*"); strings.HasSuffix(pattern, "/*") {
+			if strings.HasPrefix(p, prefix) || p == strings.TrimSuffix(prefix, "/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithHandlerName logs the name of the matched handler function as `handler` on the completion log.
+// The name is resolved by reflection after routing, so it is disabled by default.
+func WithHandlerName(enabled bool) Option {
+	return func(o *options) {
+		o.handlerName = enabled
+	}
+}
+
+// WithErrorLogger routes the completion log of 5xx responses to logger instead of the primary one.
+func WithErrorLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.errorLogger = logger
+	}
+}
+
+// WithSkipMethods disables logging for requests with any of the given methods, e.g. http.MethodOptions
+// for CORS preflight requests. Skipped requests are passed to the next handler without being wrapped.
+func WithSkipMethods(methods ...string) Option {
+	return func(o *options) {
+		if len(methods) == 0 {
+			return
+		}
+		set := make(map[string]struct{}, len(methods))
+		for _, m := range methods {
+			set[strings.ToUpper(m)] = struct{}{}
+		}
+		o.skippers = append(o.skippers, func(r *http.Request) bool {
+			_, ok := set[r.Method]
+			return ok
+		})
+	}
+}
+
+// WithResponseEncoding logs the response Content-Encoding as `responseEncoding`, which helps to verify
+// that compression middleware is effective. It is omitted for identity or absent encodings.
+func WithResponseEncoding(enabled bool) Option {
+	return func(o *options) {
+		o.responseEncoding = enabled
+	}
+}
+
+// WithRedirectTo logs the Location header of 3xx responses as `redirectTo`, to follow redirects, and
+// redirect loops, without digging into the response headers. Query parameters of WithMaskQueryParams
+// are masked. It is omitted for other statuses and responses without Location.
+func WithRedirectTo(enabled bool) Option {
+	return func(o *options) {
+		o.redirectTo = enabled
+	}
+}
+
+// WithMaxBodyBytes bounds how many bytes of the request and response bodies are read for logging.
+// The handler still receives the whole request body; only the logged part is limited, and
+// `bodyTruncated` is added when the limit is hit. It defaults to DefaultMaxBodyBytes, so that an enormous
+// body cannot make the middleware allocate it whole. Zero or less removes the limit, which reads
+// bodies whole as versions before the default did.
+func WithMaxBodyBytes(n int) Option {
+	return func(o *options) {
+		o.config.maxBodyBytes = n
+	}
+}
+
+// WithFinalizeFields appends the fields returned by fn to the completion log.
+// fn runs after the handler returns, so the whole request and response are known.
+func WithFinalizeFields(fn func(r *http.Request, status, bytes int, elapsed time.Duration) []zap.Field) Option {
+	return func(o *options) {
+		o.finalizeFields = fn
+	}
+}
+
+// WithOnStart calls fn for every request once its entry logger, carrying the request fields, is built,
+// and before the "Request started" log is written, deferred or not. It runs before the handler, so it
+// can observe request starts or log on their behalf. The context of r holds the entry, so fields set with
+// LogEntrySetField are carried by the start, handler and completion logs; log is the entry logger, and
+// fields added to it with With are not. A nil fn is a no-op.
+func WithOnStart(fn func(r *http.Request, log *zap.Logger)) Option {
+	return func(o *options) {
+		o.onStart = fn
+	}
+}
+
+// WithMultipartSummary logs multipart/form-data request bodies as a `multipart` array of
+// their parts (name, filename, contentType, size) instead of the raw body. Part contents are never logged.
+// Only the first WithMaxBodyBytes bytes are inspected, so sizes of later parts may be partial.
+func WithMultipartSummary(enabled bool) Option {
+	return func(o *options) {
+		o.multipartSummary = enabled
+	}
+}
+
+// WithRequestBodyRestore controls whether the request body read for logging is handed back to the handler.
+// Disabling it is lossy: the handler receives an empty body, which saves holding the buffered copy
+// for the lifetime of the request. Use it only when handlers never read the body. Enabled by default.
+func WithRequestBodyRestore(enabled bool) Option {
+	return func(o *options) {
+		o.restoreRequestBody = enabled
+	}
+}
+
+// WithPanicHook calls fn after a recovered panic is logged, e.g. to forward it to Sentry or another
+// error tracker. The panic is reported by the recoverer (such as middleware.Recoverer) placed after
+// this middleware, and r is the request the panicking handler was serving.
+func WithPanicHook(fn func(r *http.Request, v interface{}, stack []byte)) Option {
+	return func(o *options) {
+		o.panicHook = fn
+	}
+}
+
+// WithCombinedLog adds an `accessLog` field to the completion log holding the request formatted in the
+// Apache Combined Log Format, for pipelines which still ingest Apache/nginx style access logs. Quotes,
+// backslashes and control characters are escaped as Apache does. The user of Basic authentication is
+// logged as "-" unless the Authorization header is unmasked, with WithMasking(false).
+func WithCombinedLog(enabled bool) Option {
+	return func(o *options) {
+		o.combinedLog = enabled
+	}
+}
+
+// WithClientCert logs the leaf TLS client certificate presented on mTLS connections as a `clientCert`
+// object holding its subject CN, issuer CN and serial number. It is omitted when no certificate was presented.
+func WithClientCert(enabled bool) Option {
+	return func(o *options) {
+		o.clientCert = enabled
+	}
+}
+
+// WithLocalAddr logs the local address of the connection which served the request as `localAddr`,
+// to tell apart traffic of servers listening on several addresses. It is omitted when the server
+// did not record it in the request context.
+func WithLocalAddr(enabled bool) Option {
+	return func(o *options) {
+		o.localAddr = enabled
+	}
+}
+
+// WithBodyLogging turns request and response body logging on or off. When disabled, bodies are
+// neither buffered nor read, whatever other body options say. Enabled by default.
+// It can be changed at runtime with Config.SetBodyLogging.
+//
+// The request body is read when the request arrives, so mount the middleware before any middleware
+// consuming it. A body announced by Content-Length but found already consumed is read again from
+// Request.GetBody when a buffering middleware set it, and is otherwise logged as `bodyUnavailable`.
+//
+// When the middleware is nested, e.g. once on the router and again on a mounted sub-router,
+// the request body is logged by the outermost instance capturing it and skipped by the inner ones.
+func WithBodyLogging(enabled bool) Option {
+	return func(o *options) {
+		o.config.bodyLogging = enabled
+	}
+}
+
+// WithStatusClass adds a `statusClass` field such as "2xx" or "5xx" to the response log,
+// so log tooling can group responses without range queries.
+func WithStatusClass(enabled bool) Option {
+	return func(o *options) {
+		o.statusClass = enabled
+	}
+}
+
+// WithTrustProxy takes the scheme and host from the headers set by reverse proxies and adds the
+// original client address as `clientIP`. The Forwarded header (RFC 7239) is preferred over
+// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host. Enable it only behind trusted proxies,
+// as clients can set these headers freely.
+func WithTrustProxy(enabled bool) Option {
+	return func(o *options) {
+		o.trustProxy = enabled
+	}
+}
+
+// WithSpanID models every request as a span for log based tracing. A generated span ID is attached to
+// the start, complete and handler logs, along with the parent span ID set by ContextWithParentSpanID.
+func WithSpanID(enabled bool) Option {
+	return func(o *options) {
+		o.spanID = enabled
+	}
+}
+
+// WithSpanFieldNames changes the field names used by WithSpanID, "spanId" and "parentSpanId" by default.
+func WithSpanFieldNames(spanID, parentSpanID string) Option {
+	return func(o *options) {
+		o.spanIDKey = spanID
+		o.parentSpanIDKey = parentSpanID
+	}
+}
+
+// WithBodySampleRate logs bodies for only the given fraction (0 to 1) of requests, while metadata is
+// logged for all of them. Request bodies of requests which are not sampled are never read.
+// Response bodies of 5xx responses are logged regardless of sampling. Defaults to 1.
+func WithBodySampleRate(rate float64) Option {
+	return func(o *options) {
+		o.bodySampleRate = rate
+	}
+}
+
+// WithBaggageKeys logs the given entries of the W3C Baggage header as a `baggage` object.
+// Entries not listed are never logged, so that propagated context does not leak into logs.
+func WithBaggageKeys(keys []string) Option {
+	return func(o *options) {
+		o.baggageKeys = keys
+	}
+}
+
+// WithResponseBodyStatuses logs response bodies only for the given statuses, e.g. 422 and 500.
+// Other bodies are not buffered, as the status is known when the handler first writes the body.
+func WithResponseBodyStatuses(statuses []int) Option {
+	return func(o *options) {
+		o.responseBodyStatuses = make(map[int]struct{}, len(statuses))
+		for _, status := range statuses {
+			o.responseBodyStatuses[status] = struct{}{}
+		}
+	}
+}
+
+// WithRequestReadDuration adds `requestReadDuration` to the response log: the time between the first
+// and the last read of the request body from the client, which tells slow uploads from slow handlers.
+// Reads done for body logging are included. It is omitted when the body was never read.
+func WithRequestReadDuration(enabled bool) Option {
+	return func(o *options) {
+		o.requestReadDuration = enabled
+	}
+}
+
+// WithAuthorizationScheme logs the scheme of the Authorization header, e.g. "Bearer ***",
+// instead of masking the whole value. The credentials are masked either way.
+func WithAuthorizationScheme(enabled bool) Option {
+	return func(o *options) {
+		o.authorizationScheme = enabled
+	}
+}
+
+// WithRequestBodyCaptureMode sets when the request body is captured. Defaults to BodyCaptureEager.
+func WithRequestBodyCaptureMode(mode BodyCaptureMode) Option {
+	return func(o *options) {
+		o.requestBodyCaptureMode = mode
+	}
+}
+
+// WithRuntimeStats adds a `runtime` object with the goroutine count and heap statistics to the
+// completion log of 5xx responses. Memory statistics are read at most once per interval, as
+// runtime.ReadMemStats is costly. Zero or less disables it, which is the default.
+func WithRuntimeStats(interval time.Duration) Option {
+	return func(o *options) {
+		if interval <= 0 {
+			o.memStats = nil
+			return
+		}
+		o.memStats = &memStatsCache{interval: interval}
+	}
+}
+
+// WithMinimalRequestContext attaches only the method, path and request ID, as a `request` object,
+// to the logger handlers obtain from LogEntry. The full `httpRequest` object then appears only on
+// the start and complete logs, instead of being repeated on every handler log.
+func WithMinimalRequestContext(enabled bool) Option {
+	return func(o *options) {
+		o.minimalRequestContext = enabled
+	}
+}
+
+// WithSchema shapes the completion log with s, e.g. CloudEventsSchema, instead of the `httpResponse` object.
+func WithSchema(s Schema) Option {
+	return func(o *options) {
+		o.schema = s
+	}
+}
+
+// WithBodyExcludePaths never logs request and response bodies of requests whose path matches any
+// of patterns, such as "/admin/*". It takes precedence over every other body option, including
+// WithBodyLogging and Config.SetBodyLogging. A pattern ending with "/*" matches everything
+// below its prefix, other patterns use the path.Match syntax.
+func WithBodyExcludePaths(patterns ...string) Option {
+	return func(o *options) {
+		o.bodyExcludePaths = append(o.bodyExcludePaths, patterns...)
+	}
+}
+
+// WithBytesTotal adds `requestBytesTotal` and `responseBytesTotal` for traffic accounting. They are
+// estimates of the message sizes as HTTP/1.1: start line, header lines and body length.
+// The request body counts as its Content-Length, so bodies of unknown length are not included,
+// and neither transfer encoding nor HTTP/2 header compression is taken into account.
+func WithBytesTotal(enabled bool) Option {
+	return func(o *options) {
+		o.bytesTotal = enabled
+	}
+}
+
+// WithMaskHeaders masks the values of the given request and response headers in addition to
+// Authorization, Cookie and Set-Cookie, which are always masked.
+func WithMaskHeaders(headers ...string) Option {
+	return func(o *options) {
+		for _, h := range headers {
+			o.maskHeaders[strings.ToLower(h)] = struct{}{}
+		}
+	}
+}
+
+// WithGRPCStatus logs the grpc-status and grpc-message trailers of gRPC requests as `grpcStatus` and
+// `grpcMessage`, and logs the completion at Warn or Error level for non-OK statuses, since the HTTP
+// status of gRPC responses is usually 200.
+func WithGRPCStatus(enabled bool) Option {
+	return func(o *options) {
+		o.grpcStatus = enabled
+	}
+}
+
+// WithCoalescer folds repeated identical completion logs with c. See Coalescer.
+func WithCoalescer(c *Coalescer) Option {
+	return func(o *options) {
+		o.coalescer = c
+	}
+}
+
+// WithCookieNames logs the names, never the values, of the request cookies as `cookies` and of the
+// cookies set by the response as `setCookies`. The Cookie and Set-Cookie headers stay masked.
+func WithCookieNames(enabled bool) Option {
+	return func(o *options) {
+		o.cookieNames = enabled
+	}
+}
+
+// WithStaticField attaches a constant field, such as the release version or commit injected
+// with ldflags, to every log of the middleware. It is attached once to the logger at construction.
+func WithStaticField(key, value string) Option {
+	return func(o *options) {
+		o.staticFields = append(o.staticFields, zap.String(key, value))
+	}
+}
+
+// WithNestedBody logs bodies as `requestBody` and `responseBody` objects holding the content along
+// with its size, truncation flag and Content-Encoding, instead of flat `body` strings,
+// so downstream processors can isolate them.
+func WithNestedBody(enabled bool) Option {
+	return func(o *options) {
+		o.nestedBody = enabled
+	}
+}
+
+// WithEmptySuccessBodyCheck flags 2xx responses without a body, other than 204 and responses to
+// HEAD requests, with `emptySuccessBody`, and writes their completion log at least at level.
+// Pass zapcore.InfoLevel to flag them only, or zapcore.WarnLevel to surface them.
+func WithEmptySuccessBodyCheck(level zapcore.Level) Option {
+	return func(o *options) {
+		o.emptySuccessBody = &level
+	}
+}
+
+// WithMaskedBodyType decodes JSON request bodies of paths matching pattern into a new value of the
+// type of prototype, and logs it re-encoded with the fields tagged `log:"mask"` redacted:
+//
+//	type login struct {
+//		User     string `json:"user"`
+//		Password string `json:"password" log:"mask"`
+//	}
+//	httplog.WithMaskedBodyType("/login", login{})
+//
+// Masked strings are logged as "***" and other masked values as their zero value. Fields unknown to
+// the type are dropped, and a body which cannot be decoded into the type is not logged at all.
+// The first registered pattern matching the path wins; patterns follow WithBodyExcludePaths.
+// Bodies of paths without a registered type are masked by the keys of WithMaskJSONKeys, if any,
+// and are logged as they are otherwise.
+func WithMaskedBodyType(pattern string, prototype interface{}) Option {
+	return func(o *options) {
+		t := reflect.TypeOf(prototype)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		o.bodyTypes = append(o.bodyTypes, bodyType{pattern: pattern, typ: t})
+	}
+}
+
+// WithMaskJSONKeys masks as "***" the values of the given object members, e.g. "password" and "token",
+// at any depth of JSON request bodies, as MaskJSON does. It applies to the bodies of paths without a type
+// registered with WithMaskedBodyType. Names are matched exactly. A body which is not valid JSON, such as
+// one truncated to MaxBodyBytes, is not logged at all rather than logged unmasked.
+func WithMaskJSONKeys(keys ...string) Option {
+	return func(o *options) {
+		o.maskJSONKeys = append(o.maskJSONKeys, keys...)
+	}
+}
+
+// WithRetryHeader marks requests carrying the header name, such as X-Retry-Count, as retries with
+// `retry` and `retryCount` fields. parse tells from the header value whether the request is a retry
+// and how many times it was retried, zero if unknown. When parse is nil, an integer value is taken as
+// the retry count and any other value marks a retry of unknown count.
+func WithRetryHeader(name string, parse func(value string) (retry bool, count int)) Option {
+	return func(o *options) {
+		if parse == nil {
+			parse = parseRetryCount
+		}
+		o.retryHeader = name
+		o.retryParser = parse
+	}
+}
+
+func parseRetryCount(value string) (bool, int) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return true, 0
+	}
+	return n > 0, n
+}
+
+// WithStreaming stops buffering response bodies of the given content types, such as server-sent
+// events, which are long-lived and possibly endless. A "Request streaming" log with the bytes written
+// so far is emitted at most once per interval while such a response is written, and the completion log
+// carries `bodyLoggingSkipped: "streaming"` instead of the body. Zero interval logs no progress.
+// Without content types, text/event-stream and application/x-ndjson are treated as streams.
+func WithStreaming(interval time.Duration, contentTypes ...string) Option {
+	return func(o *options) {
+		if len(contentTypes) == 0 {
+			contentTypes = defaultStreamingTypes
+		}
+		o.streamingTypes = make(map[string]struct{}, len(contentTypes))
+		for _, t := range contentTypes {
+			o.streamingTypes[strings.ToLower(t)] = struct{}{}
+		}
+		o.streamingInterval = interval
+	}
+}
+
+// WithRouteSampling logs the completion of the first request of every route pattern within each
+// window, and only the given fraction (0 to 1) of the other requests, so every endpoint keeps at least
+// one example while the volume shrinks. 5xx responses are always logged. The decision is made once the
+// route is known, after the handler, so start logs are not sampled.
+func WithRouteSampling(window time.Duration, rate float64) Option {
+	return func(o *options) {
+		o.routeSampler = &routeSampler{window: window, rate: rate, seen: make(map[string]time.Time)}
+	}
+}
+
+// WithStatusClassSampling logs the completion of only the given fraction (0 to 1) of the requests of
+// each status class, keyed "1xx" to "4xx", e.g. 0.5 for "4xx" and 0.05 for "2xx" and "3xx".
+// Classes without a rate are all logged, and so are 5xx responses whatever their rate, to keep
+// every server error visible. Like WithRouteSampling, start logs are not sampled.
+func WithStatusClassSampling(rates map[string]float64) Option {
+	return func(o *options) {
+		o.statusClassRates = make(map[string]float64, len(rates))
+		for class, rate := range rates {
+			o.statusClassRates[strings.ToLower(class)] = rate
+		}
+	}
+}
+
+// WithAdaptiveVerbosity keeps logs of successful requests short and details failed ones:
+//
+//   - every log of the request, including the start log, carries only the `request` object with
+//     the method, path and request ID, as with WithMinimalRequestContext
+//   - the completion log of 1xx, 2xx and 3xx responses has an `httpResponse` object with only
+//     the status and the elapsed time
+//   - the completion log of 4xx and 5xx responses adds the full `httpRequest` object, with headers
+//     and body, and the full `httpResponse` object
+//
+// The request body is still read before the handler runs, since the outcome is not known yet.
+func WithAdaptiveVerbosity(enabled bool) Option {
+	return func(o *options) {
+		o.adaptiveVerbosity = enabled
+	}
+}
+
+// WithResponseHeaderFields promotes response headers to top-level fields of the completion log,
+// e.g. `map[string]string{"Location": "resourceLocation"}` logs the Location header set by the
+// handler as `resourceLocation`. Header names are case-insensitive. A header is omitted when absent.
+func WithResponseHeaderFields(fields map[string]string) Option {
+	return func(o *options) {
+		o.responseHeaderFields = make([]headerField, 0, len(fields))
+		for name, key := range fields {
+			o.responseHeaderFields = append(o.responseHeaderFields, headerField{name: http.CanonicalHeaderKey(name), key: key})
+		}
+		// Keep the field order stable across requests.
+		sort.Slice(o.responseHeaderFields, func(i, j int) bool {
+			return o.responseHeaderFields[i].name < o.responseHeaderFields[j].name
+		})
+	}
+}
+
+// headerField is a header promoted to a top-level field named key.
+type headerField struct {
+	name string
+	key  string
+}
+
+// WithMaxLogSize keeps the start and completion logs under max bytes as a last resort, for log pipelines
+// which truncate or reject long lines. A log over the limit first loses its request and response bodies,
+// then its headers, and is marked with `truncatedForSize`. Other fields are never dropped.
+//
+// As zap encodes fields lazily, a log is measured by encoding its fields once with zap's production JSON
+// encoder before it is written, which costs about as much as the log itself. The fields of the logger
+// passed to the middleware and the ones added with LogEntrySetField are not counted, nor is the overhead
+// of the actual encoder, so leave some headroom below the real limit.
+func WithMaxLogSize(max int) Option {
+	return func(o *options) {
+		o.maxLogSize = max
+	}
+}
+
+// WithDebugSampleRate logs a random sample of requests in full detail at Debug level, for troubleshooting
+// without logging every request in detail. rate is between 0 and 1. For sampled requests, marked
+// with `debugSampled`:
+//
+//   - the start and completion logs are written at Debug level, or higher for failures
+//   - request and response bodies are logged regardless of WithBodyLogging, WithBodySampleRate
+//     and WithResponseBodyStatuses, up to MaxBodyBytes, but never for the paths of WithBodyExcludePaths
+//   - the full request is logged even with WithAdaptiveVerbosity
+//   - the completion log is not dropped by WithRouteSampling or WithStatusClassSampling
+//
+// Masking and WithBodyExcludePaths still apply, so bodies and headers hidden by the privacy options stay hidden.
+// Requests are only sampled while the logger is enabled at Debug level; otherwise they are all
+// logged as usual, rather than sampled into logs which would be dropped.
+func WithDebugSampleRate(rate float64) Option {
+	return func(o *options) {
+		o.debugSampleRate = rate
+	}
+}
+
+// WithCompletionLog turns the "Request complete" log on or off; it is on by default. Without it, only the start
+// log and the logs of the handler are written, and the middleware hands the ResponseWriter to the handler as is
+// instead of wrapping it, which saves the wrapper's overhead and keeps the writer's own interfaces, such as
+// http.Hijacker or io.ReaderFrom, reachable through type assertions. Options about the response have no effect then.
+func WithCompletionLog(enabled bool) Option {
+	return func(o *options) {
+		o.completionLog = enabled
+	}
+}
+
+// WithElapsedUnits adds the elapsed time of the response as plain numbers in the given units next to
+// the `elapsed` duration, for pipelines deriving metrics from logs without parsing durations:
+// time.Second as `elapsedSeconds` and time.Millisecond as `elapsedMs`, both fractional,
+// time.Microsecond as `elapsedUs` and time.Nanosecond as `elapsedNs`. Other units are ignored.
+// Without units, seconds, milliseconds and microseconds are added.
+func WithElapsedUnits(units ...time.Duration) Option {
+	return func(o *options) {
+		if len(units) == 0 {
+			units = []time.Duration{time.Second, time.Millisecond, time.Microsecond}
+		}
+		o.elapsedUnits = units
+	}
+}
+
+// WithShouldLog decides after the handler returns whether the completion log of the request is written,
+// e.g. to drop successful static file responses while keeping their errors. Unlike WithSkipMethods,
+// it cannot take back the start log and the logs of the handler, which are already written by then.
+// fn also decides for 5xx responses, so return true for them to keep errors.
+func WithShouldLog(fn func(r *http.Request, status, bytes int, elapsed time.Duration) bool) Option {
+	return func(o *options) {
+		o.shouldLog = fn
+	}
+}
+
+// WithQueueTimeHeader adds `queueTime` to the start and completion logs: the time between the timestamp a
+// load balancer put in the header name when it received the request, e.g. "X-Request-Start", and the start
+// of the middleware. It tells time spent queued in front of the application from time spent in it.
+// The timestamp is a Unix epoch in seconds, milliseconds, microseconds or nanoseconds, optionally prefixed
+// with "t=". queueTime is omitted when the header is absent, unparseable or in the future.
+func WithQueueTimeHeader(name string) Option {
+	return func(o *options) {
+		o.queueTimeHeader = name
+	}
+}
+
+// WithPrivateContextKey stores the request's log entry in the context under a key private to this package
+// instead of chi's middleware.LogEntryCtxKey, so it does not collide with chi's own request logger or any
+// other middleware using that key. LogEntry and the other helpers of this package find the entry under
+// either key, but chi's middleware.GetLogEntry, and so middleware.Recoverer, no longer do: panics are then
+// not logged through the entry. It has no effect with LogFormatter, as chi stores the entry itself there.
+func WithPrivateContextKey(enabled bool) Option {
+	return func(o *options) {
+		o.privateContextKey = enabled
+	}
+}
+
+// WithFieldFilter drops every field whose key keep returns false for, e.g. `remoteAddr` where it must
+// not be logged for privacy reasons. It applies to the fields of the start and completion logs, at any
+// depth of the objects the middleware and the schemas log, including header names, which are lower-case.
+// Keys are matched alone, without the names of the enclosing objects. Fields added by the handler to
+// the logger are not filtered.
+func WithFieldFilter(keep func(key string) bool) Option {
+	return func(o *options) {
+		o.fieldFilter = keep
+	}
+}
+
+// WithRouteNameKey logs as `routeName` the string stored in the request context under key, a logical name
+// for the route which reads better on dashboards than its pattern. It is omitted when there is none.
+// The value has to be in the context of the request the middleware receives, so set it from a middleware
+// mounted before this one:
+//
+//	type routeNameKey struct{}
+//
+//	func routeName(name string) func(http.Handler) http.Handler {
+//		return func(next http.Handler) http.Handler {
+//			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeNameKey{}, name)))
+//			})
+//		}
+//	}
+//
+//	r.With(routeName("listOrders"), httplog.ZapRequestLogger(logger, httplog.WithRouteNameKey(routeNameKey{}))).Get("/orders", listOrders)
+//
+// Inner middlewares can use LogEntrySetField instead.
+func WithRouteNameKey(key interface{}) Option {
+	return func(o *options) {
+		o.routeNameKey = key
+	}
+}
+
+// WithOptIn turns request logging off by default: the start and completion logs of a request are only written
+// when a handler or middleware opts in by calling LogEntryEnable with the request context, e.g. for the few
+// endpoints worth logging in a high-volume service. Requests failing with 5xx or panicking are logged anyway.
+// The start log is held back until the request completes, as with WithDeferredStartLog, and written right
+// before the completion log when the request is logged, with its original time. Combined with WithDeferredStartLog,
+// the rule of WithDeferredStartLog wins: logged requests only get the start log on 4xx and 5xx responses.
+// Logs written by handlers through LogEntry are not affected. It only takes effect with ZapRequestLogger and
+// the completion log on; with WithCompletionLog(false), every start log is written when the request arrives.
+func WithOptIn(enabled bool) Option {
+	return func(o *options) {
+		o.optIn = enabled
+	}
+}
+
+// WithDeferredStartLog holds the "Request started" log back until the request completes and writes it, right
+// before the completion log and with its original time, only for 4xx and 5xx responses. Successful requests
+// then produce the completion log alone, while failed ones keep both. As the start log is no longer written
+// when the request arrives, requests which never complete, e.g. because the process crashes, leave no trace.
+// It has no effect with WithCompletionLog(false).
+func WithDeferredStartLog(enabled bool) Option {
+	return func(o *options) {
+		o.deferStartLog = enabled
+	}
+}
+
+// WithBodyParser logs the request and response bodies of the given media type, e.g. "application/json",
+// as structured objects built by parser instead of raw strings, so their fields can be queried.
+// A parser for "application/json" also applies to the "+json" media types.
+// ParseJSONBody and ParseFormBody are provided for JSON and URL-encoded forms; register others, such as
+// XML or MessagePack, the same way. Bodies are parsed after masking and only when they were captured in
+// full within MaxBodyBytes and are not compressed; bodies which cannot be parsed are logged raw.
+func WithBodyParser(mediaType string, parser BodyParser) Option {
+	return func(o *options) {
+		if o.bodyParsers == nil {
+			o.bodyParsers = make(map[string]BodyParser)
+		}
+		o.bodyParsers[strings.ToLower(mediaType)] = parser
+	}
+}
+
+// WithBodyLines logs only the first n lines of request and response bodies logged as text, followed by
+// "..." when lines were cut, to keep multi-line bodies such as text/plain error dumps scannable.
+// The lines are taken from the body captured within MaxBodyBytes, so `bodyTruncated` still tells that
+// the capture was cut by size, while "..." tells that lines were left out. Bodies logged by a BodyParser
+// or base64 encoded are not cut. Zero or less logs every line, which is the default.
+func WithBodyLines(n int) Option {
+	return func(o *options) {
+		o.bodyLines = n
+	}
+}
+
+// WithCharsetDecoding decodes logged bodies to UTF-8 from the charset parameter of their Content-Type,
+// so that bodies in other charsets are not logged garbled. UTF-8, US-ASCII and ISO-8859-1 are known;
+// register others with WithCharsetDecoder. A body without a known charset is logged as is when it is
+// valid UTF-8, and otherwise base64 encoded and marked with `bodyBase64`, or `base64` with WithNestedBody,
+// as are compressed bodies. Only the logged copy is decoded; the handler reads the body untouched.
+func WithCharsetDecoding(enabled bool) Option {
+	return func(o *options) {
+		o.charsetDecoding = enabled
+	}
+}
+
+// WithCharsetDecoder registers decoder for the charset, e.g. "shift_jis", and turns on WithCharsetDecoding.
+// decoder is called concurrently. Decoders from golang.org/x/text fit, given a new one per call, e.g. for Shift_JIS:
+//
+//	httplog.WithCharsetDecoder("shift_jis", func(body []byte) ([]byte, error) {
+//		return japanese.ShiftJIS.NewDecoder().Bytes(body)
+//	})
+func WithCharsetDecoder(charset string, decoder CharsetDecoder) Option {
+	return func(o *options) {
+		if o.charsetDecoders == nil {
+			o.charsetDecoders = make(map[string]CharsetDecoder)
+		}
+		o.charsetDecoders[strings.ToLower(charset)] = decoder
+		o.charsetDecoding = true
+	}
+}
+
+// WithMaxResponseContentLength skips buffering the body of responses announcing a Content-Length over n bytes,
+// such as large files served with http.ServeContent, and logs `bodyLoggingSkipped: "large"` instead.
+// The marker differs from the "streaming" of WithStreaming on purpose: a large file is skipped for its
+// announced size whatever its type, a stream for its type whatever its size, and logs tell which applied.
+// Writes still go through the response writer wrapper, which copies them rather than letting the server
+// use sendfile; exclude file server routes with WithBodyExcludePaths to keep zero-copy responses, as
+// the wrapper is then left without anything to copy to.
+func WithMaxResponseContentLength(n int64) Option {
+	return func(o *options) {
+		o.maxResponseContentLength = n
+	}
+}
+
+// WithServeMuxPattern logs as `pattern` the pattern net/http's ServeMux matched for the request, such as
+// "GET /items/{id}", for applications routed by the standard library rather than chi. It requires Go 1.23
+// or later and is omitted when the request was not routed by a ServeMux. The pattern is read from the
+// request passed to the next handler, so it is only available with ZapRequestLogger, not LogFormatter.
+func WithServeMuxPattern(enabled bool) Option {
+	return func(o *options) {
+		o.serveMuxPattern = enabled
+	}
+}
+
+// WithEventChannel sends a RequestEvent to ch for every completed request, for in-process consumers such as a
+// live dashboard, whether or not the completion log is written. Sends never block: events are dropped while
+// ch is full, so give it a buffer and drain it promptly. It only takes effect with ZapRequestLogger.
+//
+//	events := make(chan httplog.RequestEvent, 1024)
+//	go func() {
+//		for e := range events {
+//			dashboard.Record(e.Method, e.Path, e.Status, e.Elapsed)
+//		}
+//	}()
+//	r.Use(httplog.ZapRequestLogger(logger, httplog.WithEventChannel(events)))
+//
+// Events carry the route pattern and the request and response body sizes. To record payload size
+// histograms, WithMetrics is more direct.
+func WithEventChannel(ch chan<- RequestEvent) Option {
+	return func(o *options) {
+		o.eventChannel = ch
+	}
+}
+
+// WithResponseBodyPredicate logs a response body only when fn returns true for it, given the Content-Type
+// of the response and the captured body, e.g. to log only bodies shaped like the API's error envelope:
+//
+//	httplog.WithResponseBodyPredicate(func(contentType string, body []byte) bool {
+//		var envelope struct {
+//			Error json.RawMessage `json:"error"`
+//		}
+//		return strings.HasPrefix(contentType, "application/json") &&
+//			json.Unmarshal(body, &envelope) == nil && envelope.Error != nil
+//	})
+//
+// It applies on top of the other body options, to bodies they would log. The body may be truncated to MaxBodyBytes.
+func WithResponseBodyPredicate(fn func(contentType string, body []byte) bool) Option {
+	return func(o *options) {
+		o.responseBodyPredicate = fn
+	}
+}
+
+// WithMaskQueryParams masks the values of the given query parameters, e.g. "access_token", as "***" wherever
+// the request target is logged: `requestURI`, the Combined Log Format line and the schemas. Names are matched
+// case-insensitively. The request itself is left untouched.
+func WithMaskQueryParams(names ...string) Option {
+	return func(o *options) {
+		if o.maskQueryParams == nil {
+			o.maskQueryParams = make(map[string]struct{}, len(names))
+		}
+		for _, name := range names {
+			o.maskQueryParams[strings.ToLower(name)] = struct{}{}
+		}
+	}
+}
+
+// WithMaskedAuthSubject logs the `authSubject` recorded with LogEntrySetAuth as "***", keeping only
+// whether the request was authenticated, where the identity of users must not be logged.
+func WithMaskedAuthSubject(enabled bool) Option {
+	return func(o *options) {
+		o.maskAuthSubject = enabled
+	}
+}
+
+// WithDurationFormat sets how the `elapsed` field of the response is written, independently of WithSchema:
+// DurationNative, the default, leaves it to the encoder, while DurationMillis, DurationGCP and DurationISO8601
+// write it the same way whatever the encoder's config.
+func WithDurationFormat(f DurationFormat) Option {
+	return func(o *options) {
+		o.durationFormat = f
+	}
+}
+
+// WithBodyLogger writes the request and response bodies to logger instead of the access logs, which then stay
+// lean. They are written on completion, as a separate "Request bodies" log holding the `request` object with
+// the method, path and request ID for correlation, and `requestBody` and `responseBody` objects as with
+// WithNestedBody. Which bodies are captured is still decided by the other body options.
+func WithBodyLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.bodyLogger = logger
+	}
+}
+
+// WithPathTemplate adds `pathTemplate` to the response log, a low-cardinality form of the path to aggregate
+// requests by, e.g. for metrics derived from logs: the route pattern when chi routed the request, or else
+// the path with every segment matching one of rules replaced by its placeholder, "/users/12345" becoming
+// "/users/{id}". Without rules, DefaultPathRules apply. The raw path stays in `requestURI`.
+func WithPathTemplate(rules ...PathRule) Option {
+	return func(o *options) {
+		if len(rules) == 0 {
+			rules = DefaultPathRules
+		}
+		o.pathRules = rules
+	}
+}
+
+// WithProtoVersion adds the protocol version of the request as `protoMajor` and `protoMinor` integers and as
+// a normalized `httpVersion` string such as "1.1" or "2", which are easier to aggregate than `proto`.
+func WithProtoVersion(enabled bool) Option {
+	return func(o *options) {
+		o.protoVersion = enabled
+	}
+}
+
+// WithStartLog turns the "Request started" log on or off; it is on by default. Without it, the loggers handlers
+// obtain from LogEntry still carry the request fields, such as the method, path and request ID, and the
+// completion log is written as usual; only the start line is not. It takes precedence over WithDeferredStartLog.
+func WithStartLog(enabled bool) Option {
+	return func(o *options) {
+		o.startLog = enabled
+	}
+}
+
+// WithRouteMethods adds `routeMethods` to the response log: the methods the chi route matched for the request
+// is registered for, "*" standing for any method, which helps spot misconfigured routes. It is omitted when
+// the request was not routed by chi.
+func WithRouteMethods(enabled bool) Option {
+	return func(o *options) {
+		o.routeMethods = enabled
+	}
+}
+
+// WithMasking turns all masking on or off; it is on by default. Disabled, Authorization, Cookie and the other
+// headers of WithMaskHeaders, the bodies of WithMaskedBodyType and WithMaskJSONKeys, the query parameters of
+// WithMaskQueryParams and the subject of WithMaskedAuthSubject are all logged as is, which helps debugging authentication flows
+// locally. Never disable it in production: credentials and personal data end up in the logs.
+func WithMasking(enabled bool) Option {
+	return func(o *options) {
+		o.masking = enabled
+	}
+}
+
+// defaultMaxCookieHeaderBytes is the default cap of Cookie and Set-Cookie headers logged with masking off.
+const defaultMaxCookieHeaderBytes = 4096
+
+// WithUnmaskedCookieLimits bounds the Cookie and Set-Cookie headers logged when masking is off with WithMasking,
+// as they can grow large enough to bloat every log line. Each header value is cut to headerBytes, 4096 by default,
+// and the value of every cookie in it to valueBytes, which is unlimited by default; the cuts are marked with "...".
+// Zero or less removes a limit. Masked headers are logged as "***" and are not concerned.
+func WithUnmaskedCookieLimits(headerBytes, valueBytes int) Option {
+	return func(o *options) {
+		o.maxCookieHeaderBytes = headerBytes
+		o.maxCookieValueBytes = valueBytes
+	}
+}
+
+// WithHandlerLogCount adds `handlerLogCount` to the completion log: how many logs were written during the request
+// through loggers obtained from LogEntry or RawLogEntry, to find chatty handlers. Logs below the logger's level
+// are not counted, nor are those of DetachedLogEntry loggers.
+func WithHandlerLogCount(enabled bool) Option {
+	return func(o *options) {
+		o.handlerLogCount = enabled
+	}
+}
+
+// WithRequestTime adds `requestTime` to the start and completion logs: the time the request arrived, formatted
+// with layout, e.g. time.RFC3339Nano. Unlike the timestamp of the completion log, written when the request
+// completes, it tells when a long request began. An empty layout disables it.
+func WithRequestTime(layout string) Option {
+	return func(o *options) {
+		o.requestTimeLayout = layout
+	}
+}
+
+// WithRecover makes the middleware recover panics of the handler itself, instead of relying on a recoverer
+// such as middleware.Recoverer placed after it: the panic is logged with its stack and WithPanicHook is called
+// as with a recoverer, then 500 is responded and logged on the completion log. http.ErrAbortHandler is passed
+// through. It only takes effect with ZapRequestLogger, and also recovers with WithCompletionLog(false).
+func WithRecover(enabled bool) Option {
+	return func(o *options) {
+		o.recoverPanics = enabled
+	}
+}
+
+// WithJSONBodies logs JSON request and response bodies as JSON values instead of escaped strings, keeping
+// them queryable; it is WithBodyParser("application/json", ParseJSONBody), which also covers the "+json"
+// media types such as application/problem+json. Bodies which are not valid JSON, or truncated to
+// MaxBodyBytes, are logged as strings.
+func WithJSONBodies(enabled bool) Option {
+	return func(o *options) {
+		if !enabled {
+			delete(o.bodyParsers, "application/json")
+			return
+		}
+		WithBodyParser("application/json", ParseJSONBody)(o)
+	}
+}
+
+// WithServerUptime adds `serverUptime` to the start and completion logs: the time between the construction of
+// the middleware, usually the start of the process, and the arrival of the request. It helps tell bursts of
+// errors following deployments and restarts, or growing with the age of the process, from the others.
+func WithServerUptime(enabled bool) Option {
+	return func(o *options) {
+		o.serverUptime = enabled
+	}
+}
+
+// WithAcceptHeaders adds the content negotiation headers Accept, Accept-Encoding and Accept-Language to the start
+// and completion logs as `accept`, `acceptEncoding` and `acceptLanguage` arrays of their values, e.g.
+// ["text/html", "application/json"], for analyzing negotiation. Absent headers are omitted.
+// Quality values are dropped unless WithAcceptQuality is set.
+func WithAcceptHeaders(enabled bool) Option {
+	return func(o *options) {
+		o.acceptHeaders = enabled
+	}
+}
+
+// WithAcceptQuality logs the values of WithAcceptHeaders as objects holding the `value` and its quality `q`,
+// 1 when not given, e.g. {"value": "en", "q": 0.8}.
+func WithAcceptQuality(enabled bool) Option {
+	return func(o *options) {
+		o.acceptQuality = enabled
+	}
+}
+
+// WithRequestHeaders turns the `header` object of the request log on or off; it is on by default.
+func WithRequestHeaders(enabled bool) Option {
+	return func(o *options) {
+		o.requestHeaders = enabled
+	}
+}
+
+// WithResponseHeaders turns the `header` object of the response log on or off; it is on by default.
+// Response headers such as Content-Security-Policy or caching headers are often noise, unlike request headers.
+func WithResponseHeaders(enabled bool) Option {
+	return func(o *options) {
+		o.responseHeaders = enabled
+	}
+}
+
+// WithRequestContentType turns the `contentType` field of the request log, the Content-Type the request
+// declares for its body, on or off; it is on by default, also in the minimal request object of
+// WithMinimalRequestContext and WithAdaptiveVerbosity. It is omitted for requests without Content-Type.
+func WithRequestContentType(enabled bool) Option {
+	return func(o *options) {
+		o.requestContentType = enabled
+	}
+}
+
+// WithRequestIDExtractor takes the request ID logged as `requestID` from fn, e.g. HeaderRequestID("X-Request-Id"),
+// AmazonTraceRequestID or CloudTraceRequestID, to follow the IDs of other ecosystems. The ID set by
+// middleware.RequestID is used when fn returns an empty string. It also applies to the schemas and
+// the events of WithEventChannel.
+func WithRequestIDExtractor(fn func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.requestIDExtractor = fn
+	}
+}
+
+// WithSlowThreshold logs requests which take d or longer with `slow: true`, at Warn unless their level
+// is already higher. Zero, the default, disables it. It can be changed at runtime with Config.SetSlowThreshold.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.config.slowThreshold = d
+	}
+}
+
+// WithMetrics records the request and response body sizes of every completed request to m, labeled by
+// method and route, so that payload size percentiles can be tracked for capacity planning. Like
+// WithEventChannel, it only takes effect with ZapRequestLogger, and not with WithCompletionLog(false).
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
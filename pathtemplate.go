@@ -0,0 +1,37 @@
+package httplog
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// PathRule replaces the path segments matching Pattern as a whole with Placeholder, see WithPathTemplate.
+type PathRule struct {
+	Pattern     *regexp.Regexp
+	Placeholder string
+}
+
+// DefaultPathRules replace integer segments with "{id}" and UUID segments with "{uuid}".
+var DefaultPathRules = []PathRule{
+	{Pattern: regexp.MustCompile(`^[0-9]+$`), Placeholder: "{id}"},
+	{Pattern: regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`), Placeholder: "{uuid}"},
+}
+
+// pathTemplate returns the route pattern chi matched for r, or else its path with the segments matching
+// rules replaced by their placeholders.
+func pathTemplate(r *http.Request, rules []PathRule) string {
+	if pattern := routePattern(r); pattern != "" {
+		return pattern
+	}
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		for _, rule := range rules {
+			if segment != "" && rule.Pattern.MatchString(segment) {
+				segments[i] = rule.Placeholder
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
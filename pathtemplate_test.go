@@ -0,0 +1,46 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestPathTemplate(t *testing.T) {
+	hash := PathRule{Pattern: regexp.MustCompile(`^[0-9a-f]{40}$`), Placeholder: "{sha}"}
+	tests := []struct {
+		name  string
+		rules []PathRule
+		chi   bool
+		path  string
+		want  string
+	}{
+		{"integer", nil, false, "/users/12345/orders/7", "/users/{id}/orders/{id}"},
+		{"uuid", nil, false, "/files/0b7e5c4a-1f2d-4c3b-9a8e-123456789abc", "/files/{uuid}"},
+		{"partial match kept", nil, false, "/users/v2", "/users/v2"},
+		{"trailing slash", nil, false, "/users/1/", "/users/{id}/"},
+		{"custom rules", []PathRule{hash}, false, "/commits/0123456789abcdef0123456789abcdef01234567/1", "/commits/{sha}/1"},
+		{"chi route", nil, true, "/users/12345", "/users/{userID}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			mw := ZapRequestLogger(logger, WithPathTemplate(tt.rules...))
+			var h http.Handler = mw(writeBody(http.StatusOK, ""))
+			if tt.chi {
+				router := chi.NewRouter()
+				router.Use(mw)
+				router.Get("/users/{userID}", writeBody(http.StatusOK, ""))
+				h = router
+			}
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")["pathTemplate"]; got != tt.want {
+				t.Errorf("pathTemplate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build go1.23
+
+package httplog
+
+import "net/http"
+
+// requestPattern returns the pattern net/http's ServeMux matched for r.
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
@@ -0,0 +1,10 @@
+//go:build !go1.23
+
+package httplog
+
+import "net/http"
+
+// requestPattern returns an empty string, as http.Request has no Pattern before Go 1.23.
+func requestPattern(r *http.Request) string {
+	return ""
+}
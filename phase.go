@@ -0,0 +1,40 @@
+package httplog
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type phaseMark struct {
+	name string
+	at   time.Time
+}
+
+// LogEntryMarkPhase records that the request reached the phase name, e.g. when an auth middleware
+// finishes. The completion log then has a `phases` object holding, for every mark, the time elapsed
+// since the previous mark (or since the request started for the first one).
+func LogEntryMarkPhase(ctx context.Context, name string) {
+	if entry, ok := entryFromContext(ctx); ok {
+		now := time.Now()
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.phases = append(entry.phases, phaseMark{name: name, at: now})
+	}
+}
+
+type phasesLog struct {
+	start time.Time
+	marks []phaseMark
+}
+
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (p *phasesLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	prev := p.start
+	for _, m := range p.marks {
+		enc.AddDuration(m.name, m.at.Sub(prev))
+		prev = m.at
+	}
+	return nil
+}
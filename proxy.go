@@ -0,0 +1,105 @@
+package httplog
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// forwardedInfo is what proxies reported about the original request.
+type forwardedInfo struct {
+	clientIP string
+	scheme   string
+	host     string
+}
+
+// forwarded derives the original client IP, scheme and host of a proxied request. The standard
+// Forwarded header (RFC 7239) is preferred, then the X-Forwarded-* headers, then the request itself.
+// Only the first (client-most) proxy hop is considered.
+func forwarded(r *http.Request) forwardedInfo {
+	var info forwardedInfo
+	if elems := parseForwarded(r.Header.Values("Forwarded")); len(elems) > 0 {
+		first := elems[0]
+		info.clientIP = forwardedNode(first["for"])
+		info.scheme = strings.ToLower(first["proto"])
+		info.host = first["host"]
+	}
+	if info.clientIP == "" {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			info.clientIP = strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+	}
+	if info.scheme == "" {
+		info.scheme = strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+	}
+	if info.host == "" {
+		info.host = strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0])
+	}
+
+	if info.clientIP == "" {
+		info.clientIP = forwardedNode(r.RemoteAddr)
+	}
+	if info.scheme == "" {
+		info.scheme = "http"
+		if r.TLS != nil {
+			info.scheme = "https"
+		}
+	}
+	if info.host == "" {
+		info.host = r.Host
+	}
+	return info
+}
+
+// parseForwarded parses Forwarded header values into their elements, one map of lower-cased
+// parameter names to unquoted values per proxy hop.
+func parseForwarded(values []string) []map[string]string {
+	var elems []map[string]string
+	for _, v := range values {
+		for _, elem := range splitQuoted(v, ',') {
+			pairs := make(map[string]string)
+			for _, pair := range splitQuoted(elem, ';') {
+				k, val, ok := strings.Cut(pair, "=")
+				if !ok {
+					continue
+				}
+				val = strings.TrimSpace(val)
+				if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+					val = strings.ReplaceAll(val[1:len(val)-1], `\"`, `"`)
+				}
+				pairs[strings.ToLower(strings.TrimSpace(k))] = val
+			}
+			if len(pairs) > 0 {
+				elems = append(elems, pairs)
+			}
+		}
+	}
+	return elems
+}
+
+// splitQuoted splits s at sep, ignoring separators inside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// forwardedNode strips the port and IPv6 brackets from a node such as `[2001:db8::17]:4711`.
+// Obfuscated identifiers and "unknown" are returned as they are.
+func forwardedNode(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
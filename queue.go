@@ -0,0 +1,46 @@
+package httplog
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queueTime returns the time between the timestamp in the header name, set by a load balancer when it
+// received the request, and now. ok is false when the header is absent or unparseable, or when the
+// timestamp is in the future because of clock skew.
+func queueTime(header http.Header, name string, now time.Time) (time.Duration, bool) {
+	start, ok := parseRequestStart(header.Get(name))
+	if !ok || start.After(now) {
+		return 0, false
+	}
+	return now.Sub(start), true
+}
+
+// parseRequestStart parses an X-Request-Start style timestamp: a Unix epoch, optionally prefixed
+// with "t=" as nginx and Heroku do. The unit, from seconds to nanoseconds, is inferred from the
+// magnitude and fractional seconds are accepted, e.g. "t=1700000000.123".
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	if v == "" {
+		return time.Time{}, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) {
+		return time.Time{}, false
+	}
+	var unit float64
+	switch {
+	case f < 1e11:
+		unit = float64(time.Second)
+	case f < 1e14:
+		unit = float64(time.Millisecond)
+	case f < 1e17:
+		unit = float64(time.Microsecond)
+	default:
+		unit = float64(time.Nanosecond)
+	}
+	return time.Unix(0, int64(f*unit)), true
+}
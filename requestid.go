@@ -0,0 +1,48 @@
+package httplog
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requestID returns the ID of the request found by WithRequestIDExtractor, falling back to the one set
+// by middleware.RequestID.
+func (o *options) requestID(r *http.Request) string {
+	if o.requestIDExtractor != nil {
+		if id := o.requestIDExtractor(r); id != "" {
+			return id
+		}
+	}
+	return middleware.GetReqID(r.Context())
+}
+
+// HeaderRequestID returns a request ID extractor for WithRequestIDExtractor reading the header as is,
+// e.g. "X-Request-Id".
+func HeaderRequestID(header string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// AmazonTraceRequestID is a request ID extractor for WithRequestIDExtractor reading the Root of the
+// X-Amzn-Trace-Id header set by AWS load balancers, e.g. "1-67891233-abcdef012345678912345678".
+func AmazonTraceRequestID(r *http.Request) string {
+	for _, part := range strings.Split(r.Header.Get("X-Amzn-Trace-Id"), ";") {
+		if part = strings.TrimSpace(part); strings.HasPrefix(part, "Root=") {
+			return strings.TrimPrefix(part, "Root=")
+		}
+	}
+	return ""
+}
+
+// CloudTraceRequestID is a request ID extractor for WithRequestIDExtractor reading the trace ID of the
+// X-Cloud-Trace-Context header set by Google Cloud load balancers, "TRACE_ID/SPAN_ID;o=OPTIONS".
+func CloudTraceRequestID(r *http.Request) string {
+	v := r.Header.Get("X-Cloud-Trace-Context")
+	if i := strings.IndexAny(v, "/;"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,127 @@
+package httplog
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// routeHandler finds the endpoint handler chi matched for the request. It returns nil when it cannot be resolved.
+func routeHandler(r *http.Request) http.Handler {
+	route := matchedRoute(r)
+	if route == nil {
+		return nil
+	}
+	if h, ok := route.Handlers[r.Method]; ok {
+		return h
+	}
+	return route.Handlers["*"]
+}
+
+// matchedRoute finds the route chi matched for the request by walking the routing tree along the
+// matched pattern stack. It returns nil when it cannot be resolved.
+func matchedRoute(r *http.Request) *chi.Route {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil || len(rctx.RoutePatterns) == 0 {
+		return nil
+	}
+	routes := rctx.Routes
+	for i, pattern := range rctx.RoutePatterns {
+		if routes == nil {
+			return nil
+		}
+		var found *chi.Route
+		for _, route := range routes.Routes() {
+			if route.Pattern == pattern {
+				route := route
+				found = &route
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		if i < len(rctx.RoutePatterns)-1 {
+			routes = found.SubRoutes
+			continue
+		}
+		return found
+	}
+	return nil
+}
+
+// routeDeclaredMethods returns the sorted methods the route chi matched for the request is registered for,
+// "*" standing for a route handling any method.
+func routeDeclaredMethods(r *http.Request) []string {
+	route := matchedRoute(r)
+	if route == nil {
+		return nil
+	}
+	methods := make([]string, 0, len(route.Handlers))
+	for method := range route.Handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// handlerName returns the function name of the handler, unwrapping chi's inline middleware chains.
+func handlerName(h http.Handler) string {
+	for {
+		c, ok := h.(*chi.ChainHandler)
+		if !ok {
+			break
+		}
+		h = c.Endpoint
+	}
+	switch fn := h.(type) {
+	case nil:
+		return ""
+	case http.HandlerFunc:
+		if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+			return f.Name()
+		}
+		return ""
+	default:
+		return fmt.Sprintf("%T", fn)
+	}
+}
+
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// routeAllowedMethods reports whether chi has a route for the request, and if not, which methods
+// the path is routed for. ok is false when the request was not routed by chi.
+func routeAllowedMethods(r *http.Request) (matched bool, allowed []string, ok bool) {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return false, nil, false
+	}
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	if rctx.Routes.Match(chi.NewRouteContext(), r.Method, path) {
+		return true, nil, true
+	}
+	for _, method := range routeMethods {
+		if method != r.Method && rctx.Routes.Match(chi.NewRouteContext(), method, path) {
+			allowed = append(allowed, method)
+		}
+	}
+	return false, allowed, true
+}
+
+// routePattern returns the full route pattern chi matched for the request, or an empty string.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRouteAllowedMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		matched interface{}
+		allowed interface{}
+	}{
+		{"matched", http.MethodGet, "/items", http.StatusOK, nil, nil},
+		{"application 404", http.MethodGet, "/items/missing", http.StatusNotFound, nil, nil},
+		{"no route", http.MethodGet, "/missing", http.StatusNotFound, false, nil},
+		{"method not allowed", http.MethodDelete, "/items", http.StatusMethodNotAllowed, false, []interface{}{"GET", "POST"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			r := chi.NewRouter()
+			r.Use(ZapRequestLogger(logger))
+			r.Get("/items", writeBody(http.StatusOK, "[]"))
+			r.Post("/items", writeBody(http.StatusCreated, "{}"))
+			r.Get("/items/{id}", writeBody(http.StatusNotFound, "not found"))
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			response := object(t, loggedFields(t, logs, "Request complete"), "httpResponse")
+			if got := response["routeMatched"]; got != tt.matched {
+				t.Errorf("routeMatched = %v, want %v", got, tt.matched)
+			}
+			if got := response["allowedMethods"]; !reflect.DeepEqual(got, tt.allowed) {
+				t.Errorf("allowedMethods = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
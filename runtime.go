@@ -0,0 +1,41 @@
+package httplog
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// memStatsCache reads runtime.MemStats at most once per interval, since ReadMemStats stops the world.
+type memStatsCache struct {
+	mu       sync.Mutex
+	interval time.Duration
+	readAt   time.Time
+	stats    runtime.MemStats
+}
+
+func (c *memStatsCache) get() runtime.MemStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := time.Now(); now.Sub(c.readAt) >= c.interval {
+		runtime.ReadMemStats(&c.stats)
+		c.readAt = now
+	}
+	return c.stats
+}
+
+type runtimeLog struct {
+	goroutines int
+	mem        runtime.MemStats
+}
+
+// implement interface of zapcore.ObjectMarshaler https://github.com/uber-go/zap/blob/v1.21.0/zapcore/marshaler.go#L31
+func (r *runtimeLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("goroutines", r.goroutines)
+	enc.AddUint64("heapAlloc", r.mem.HeapAlloc)
+	enc.AddUint64("heapObjects", r.mem.HeapObjects)
+	enc.AddUint32("numGC", r.mem.NumGC)
+	return nil
+}
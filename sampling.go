@@ -0,0 +1,32 @@
+package httplog
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// sampled reports whether an event kept at rate (0 to 1) is kept this time.
+func sampled(rate float64) bool {
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// routeSampler keeps the first completion log of every route pattern per window and
+// a sample of the rest.
+type routeSampler struct {
+	window time.Duration
+	rate   float64
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func (s *routeSampler) keep(route string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if start, ok := s.seen[route]; !ok || now.Sub(start) >= s.window {
+		s.seen[route] = now
+		return true
+	}
+	return sampled(s.rate)
+}
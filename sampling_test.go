@@ -0,0 +1,155 @@
+package httplog
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// sampleRequests is the number of requests the sampling tests serve per case. With it, the tolerance
+// below is over six standard deviations of the sampled fraction for any rate.
+const sampleRequests = 10000
+
+const sampleTolerance = 0.035
+
+// checkRate fails unless got out of sampleRequests is within sampleTolerance of rate.
+func checkRate(t *testing.T, what string, got int, rate float64) {
+	t.Helper()
+	if ratio := float64(got) / sampleRequests; math.Abs(ratio-rate) > sampleTolerance {
+		t.Errorf("%s for %.3f of the requests, want %.3f ± %.3f", what, ratio, rate, sampleTolerance)
+	}
+}
+
+func TestBodySampleRate(t *testing.T) {
+	tests := []struct {
+		rate   float64
+		status int
+		// want is the expected fraction of requests with the response body logged.
+		want float64
+	}{
+		{0, http.StatusOK, 0},
+		{0.1, http.StatusOK, 0.1},
+		{0.5, http.StatusBadRequest, 0.5},
+		{1, http.StatusOK, 1},
+		{0, http.StatusInternalServerError, 1},
+		{0.1, http.StatusServiceUnavailable, 1},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.InfoLevel)
+		h := ZapRequestLogger(logger, WithBodySampleRate(tt.rate))(writeBody(tt.status, "response"))
+		for i := 0; i < sampleRequests; i++ {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("request")))
+		}
+		var requestBodies, responseBodies int
+		for _, e := range logs.FilterMessage("Request started").All() {
+			if _, ok := e.ContextMap()["httpRequest"].(map[string]interface{})["body"]; ok {
+				requestBodies++
+			}
+		}
+		for _, e := range logs.FilterMessage("Request complete").All() {
+			if _, ok := e.ContextMap()["httpResponse"].(map[string]interface{})["body"]; ok {
+				responseBodies++
+			}
+		}
+		// Request bodies are read before the status is known, so they follow the rate alone.
+		checkRate(t, "request body logged", requestBodies, tt.rate)
+		checkRate(t, "response body logged", responseBodies, tt.want)
+	}
+}
+
+func TestDebugSampleRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		level zapcore.Level
+		rate  float64
+		// want is the expected fraction of requests logged at Debug in full detail.
+		want float64
+	}{
+		{"debug logger", zapcore.DebugLevel, 0.1, 0.1},
+		{"debug logger, all", zapcore.DebugLevel, 1, 1},
+		{"info logger", zapcore.InfoLevel, 0.1, 0},
+		{"info logger, all", zapcore.InfoLevel, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(tt.level)
+			h := ZapRequestLogger(logger, WithDebugSampleRate(tt.rate), WithBodyLogging(false))(writeBody(http.StatusOK, "response"))
+			for i := 0; i < sampleRequests; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+			completed := logs.FilterMessage("Request complete").All()
+			if len(completed) != sampleRequests {
+				t.Fatalf("got %d completion logs, want every one of the %d requests", len(completed), sampleRequests)
+			}
+			var debug int
+			for _, e := range completed {
+				sampled := e.Level == zapcore.DebugLevel
+				if sampled {
+					debug++
+				}
+				_, body := e.ContextMap()["httpResponse"].(map[string]interface{})["body"]
+				if body != sampled {
+					t.Fatalf("body logged %v at level %v, want bodies for debug sampled requests only", body, e.Level)
+				}
+			}
+			checkRate(t, "debug sampled", debug, tt.want)
+		})
+	}
+}
+
+func TestStatusClassSampling(t *testing.T) {
+	rates := map[string]float64{"2XX": 0.1, "3xx": 0, "4xx": 0.5, "5xx": 0}
+	tests := []struct {
+		status int
+		// want is the expected fraction of requests with a completion log.
+		want float64
+	}{
+		{http.StatusOK, 0.1},
+		{http.StatusNoContent, 0.1},
+		{http.StatusFound, 0},
+		{http.StatusNotFound, 0.5},
+		{http.StatusInternalServerError, 1},
+		{http.StatusSwitchingProtocols, 1},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.InfoLevel)
+		h := ZapRequestLogger(logger, WithStatusClassSampling(rates))(writeBody(tt.status, ""))
+		for i := 0; i < sampleRequests; i++ {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+		if got := logs.FilterMessage("Request started").Len(); got != sampleRequests {
+			t.Errorf("status %d: got %d start logs, want every one of the %d requests", tt.status, got, sampleRequests)
+		}
+		checkRate(t, fmt.Sprintf("status %d: completion logged", tt.status), logs.FilterMessage("Request complete").Len(), tt.want)
+	}
+}
+
+func TestDebugSampleRateBodyExcludePaths(t *testing.T) {
+	tests := []struct {
+		path   string
+		bodies bool
+	}{
+		{"/admin/keys", false},
+		{"/items", true},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		serve(logger, writeBody(http.StatusOK, "response"), httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader("request")),
+			WithDebugSampleRate(1), WithBodyExcludePaths("/admin/*"))
+		started, completed := loggedFields(t, logs, "Request started"), loggedFields(t, logs, "Request complete")
+		if completed["debugSampled"] != true {
+			t.Errorf("%s: debugSampled = %v, want true", tt.path, completed["debugSampled"])
+		}
+		if _, ok := object(t, started, "httpRequest")["body"]; ok != tt.bodies {
+			t.Errorf("%s: request body logged %v, want %v", tt.path, ok, tt.bodies)
+		}
+		if _, ok := object(t, completed, "httpResponse")["body"]; ok != tt.bodies {
+			t.Errorf("%s: response body logged %v, want %v", tt.path, ok, tt.bodies)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package httplog
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Completion describes a finished request handed to a Schema.
+type Completion struct {
+	Request *http.Request
+	// Header is the response header.
+	Header  http.Header
+	Status  int
+	Bytes   int
+	Start   time.Time
+	Elapsed time.Duration
+
+	options *options
+}
+
+// RequestID returns the request ID found by WithRequestIDExtractor or set by middleware.RequestID, if any.
+func (c *Completion) RequestID() string {
+	if c.options == nil {
+		return middleware.GetReqID(c.Request.Context())
+	}
+	return c.options.requestID(c.Request)
+}
+
+// RequestURI returns the RequestURI of the request, with the query parameters of WithMaskQueryParams masked.
+func (c *Completion) RequestURI() string {
+	if c.options == nil {
+		return c.Request.RequestURI
+	}
+	return c.options.requestURI(c.Request)
+}
+
+// Schema shapes the completion log: it returns the fields logged in place of the `httpResponse` object.
+type Schema func(c *Completion) []zap.Field
+
+// CloudEventsSchema wraps the completion log in a CloudEvents 1.0 envelope with the given source and type,
+// so access logs can flow into event buses. The request ID is used as the event ID when it is available.
+func CloudEventsSchema(source, eventType string) Schema {
+	return func(c *Completion) []zap.Field {
+		id := c.RequestID()
+		if id == "" {
+			id = newID()
+		}
+		return []zap.Field{
+			zap.String("specversion", "1.0"),
+			zap.String("id", id),
+			zap.String("source", source),
+			zap.String("type", eventType),
+			zap.String("time", c.Start.UTC().Format(time.RFC3339Nano)),
+			zap.String("datacontenttype", "application/json"),
+			zap.Object("data", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("method", c.Request.Method)
+				enc.AddString("url", c.RequestURI())
+				enc.AddString("remoteAddr", c.Request.RemoteAddr)
+				if ua := c.Request.UserAgent(); ua != "" {
+					enc.AddString("userAgent", ua)
+				}
+				enc.AddInt("status", c.Status)
+				enc.AddInt("bytes", c.Bytes)
+				enc.AddDuration("elapsed", c.Elapsed)
+				return nil
+			})),
+		}
+	}
+}
+
+// OpenTelemetry severity numbers, see https://opentelemetry.io/docs/specs/otel/logs/data-model/#field-severitynumber
+const (
+	otelSeverityInfo  = 9
+	otelSeverityWarn  = 13
+	otelSeverityError = 17
+)
+
+// OTelSchema shapes the completion log after the OpenTelemetry log data model, so access logs can flow
+// into OpenTelemetry log pipelines: `timeUnixNano`, `severityNumber`, `severityText`, a `body` summarizing
+// the request, and `attributes` named after the HTTP semantic conventions such as `http.request.method`
+// and `http.response.status_code`. The severity follows the status, WARN for 4xx and ERROR for 5xx,
+// whatever the level of the zap entry.
+func OTelSchema() Schema {
+	return func(c *Completion) []zap.Field {
+		number, text := otelSeverityInfo, "INFO"
+		switch {
+		case c.Status >= http.StatusInternalServerError:
+			number, text = otelSeverityError, "ERROR"
+		case c.Status >= http.StatusBadRequest:
+			number, text = otelSeverityWarn, "WARN"
+		}
+		r := c.Request
+		return []zap.Field{
+			zap.Int64("timeUnixNano", c.Start.UnixNano()),
+			zap.Int("severityNumber", number),
+			zap.String("severityText", text),
+			zap.String("body", fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, c.Status)),
+			zap.Object("attributes", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				scheme := "http"
+				if r.TLS != nil {
+					scheme = "https"
+				}
+				enc.AddString("http.request.method", r.Method)
+				enc.AddString("url.scheme", scheme)
+				enc.AddString("url.path", r.URL.Path)
+				if r.URL.RawQuery != "" {
+					query := r.URL.RawQuery
+					if c.options != nil {
+						query = c.options.maskQuery(query)
+					}
+					enc.AddString("url.query", query)
+				}
+				if route := routePattern(r); route != "" {
+					enc.AddString("http.route", route)
+				}
+				enc.AddString("server.address", r.Host)
+				enc.AddString("client.address", r.RemoteAddr)
+				enc.AddString("network.protocol.version", fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor))
+				if ua := r.UserAgent(); ua != "" {
+					enc.AddString("user_agent.original", ua)
+				}
+				enc.AddInt("http.response.status_code", c.Status)
+				enc.AddInt("http.response.body.size", c.Bytes)
+				enc.AddFloat64("http.server.request.duration", c.Elapsed.Seconds())
+				return nil
+			})),
+		}
+	}
+}
+
+// LokiSchema splits the completion log into a `labels` object of low-cardinality fields, fit to be
+// Loki labels, and a `fields` object of the high-cardinality rest, kept in the log line:
+//
+//   - labels: `method`, `statusClass` such as "2xx", and `route`, the chi route pattern, or
+//     "unmatched" when chi did not route the request, so that raw paths never become labels
+//   - fields: `path`, `requestURI`, `requestID`, `remoteAddr`, `userAgent`, `status`, `bytes`
+//     and `elapsed` in seconds
+//
+// zap does not set labels itself; extract them in the ingestion pipeline, e.g. with Promtail:
+//
+//	pipeline_stages:
+//	  - json:
+//	      expressions:
+//	        method: labels.method
+//	        status_class: labels.statusClass
+//	        route: labels.route
+//	  - labels:
+//	      method:
+//	      status_class:
+//	      route:
+//
+// The request ID and other per-request values should stay out of the labels.
+func LokiSchema() Schema {
+	return func(c *Completion) []zap.Field {
+		r := c.Request
+		route := routePattern(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		return []zap.Field{
+			zap.Object("labels", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("method", r.Method)
+				enc.AddString("statusClass", statusClass(c.Status))
+				enc.AddString("route", route)
+				return nil
+			})),
+			zap.Object("fields", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("path", r.URL.Path)
+				enc.AddString("requestURI", c.RequestURI())
+				if id := c.RequestID(); id != "" {
+					enc.AddString("requestID", id)
+				}
+				enc.AddString("remoteAddr", r.RemoteAddr)
+				if ua := r.UserAgent(); ua != "" {
+					enc.AddString("userAgent", ua)
+				}
+				enc.AddInt("status", c.Status)
+				enc.AddInt("bytes", c.Bytes)
+				enc.AddFloat64("elapsed", c.Elapsed.Seconds())
+				return nil
+			})),
+		}
+	}
+}
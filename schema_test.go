@@ -0,0 +1,170 @@
+package httplog
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCloudEventsSchema(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID bool
+	}{
+		{"with request ID", true},
+		{"without request ID", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			var h http.Handler = ZapRequestLogger(logger, WithSchema(CloudEventsSchema("/api", "com.example.access")))(writeBody(http.StatusCreated, "{}"))
+			var requestID string
+			if tt.requestID {
+				h = middleware.RequestID(h)
+				requestID = "req-1"
+			}
+			r := httptest.NewRequest(http.MethodPost, "/items?q=1", nil)
+			r.Header.Set("X-Request-Id", requestID)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			fields := loggedFields(t, logs, "Request complete")
+			for k, want := range map[string]interface{}{
+				"specversion":     "1.0",
+				"source":          "/api",
+				"type":            "com.example.access",
+				"datacontenttype": "application/json",
+			} {
+				if fields[k] != want {
+					t.Errorf("%s = %v, want %v", k, fields[k], want)
+				}
+			}
+			if id, _ := fields["id"].(string); id == "" || tt.requestID && id != requestID {
+				t.Errorf("id = %q, want %q", id, requestID)
+			}
+			if _, ok := fields["httpResponse"]; ok {
+				t.Error("httpResponse is logged along with the schema")
+			}
+			data := object(t, fields, "data")
+			for k, want := range map[string]interface{}{"method": "POST", "url": "/items?q=1", "status": http.StatusCreated, "bytes": 2} {
+				if data[k] != want {
+					t.Errorf("data.%s = %#v, want %#v", k, data[k], want)
+				}
+			}
+		})
+	}
+}
+
+func TestOTelSchema(t *testing.T) {
+	tests := []struct {
+		status         int
+		severityNumber int64
+		severityText   string
+	}{
+		{http.StatusOK, 9, "INFO"},
+		{http.StatusNotFound, 13, "WARN"},
+		{http.StatusInternalServerError, 17, "ERROR"},
+	}
+	for _, tt := range tests {
+		logger, logs := newObservedLogger(zapcore.DebugLevel)
+		router := chi.NewRouter()
+		router.Use(ZapRequestLogger(logger, WithSchema(OTelSchema()), WithMaskQueryParams("token")))
+		router.Get("/items/{id}", writeBody(tt.status, "body"))
+		r := httptest.NewRequest(http.MethodGet, "/items/1?token=secret&q=1", nil)
+		r.Header.Set("User-Agent", "test")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		fields := loggedFields(t, logs, "Request complete")
+		if fields["severityNumber"] != tt.severityNumber || fields["severityText"] != tt.severityText {
+			t.Errorf("status %d: severity = %v %v, want %v %v", tt.status, fields["severityNumber"], fields["severityText"], tt.severityNumber, tt.severityText)
+		}
+		if _, ok := fields["timeUnixNano"].(int64); !ok {
+			t.Errorf("status %d: timeUnixNano = %#v, want an int64", tt.status, fields["timeUnixNano"])
+		}
+		if want := fmt.Sprintf("GET /items/1 %d", tt.status); fields["body"] != want {
+			t.Errorf("status %d: body = %v, want %v", tt.status, fields["body"], want)
+		}
+		attributes := object(t, fields, "attributes")
+		for k, want := range map[string]interface{}{
+			"http.request.method":       "GET",
+			"url.scheme":                "http",
+			"url.path":                  "/items/1",
+			"url.query":                 "token=***&q=1",
+			"http.route":                "/items/{id}",
+			"server.address":            "example.com",
+			"client.address":            "192.0.2.1:1234",
+			"network.protocol.version":  "1.1",
+			"user_agent.original":       "test",
+			"http.response.status_code": tt.status,
+			"http.response.body.size":   4,
+		} {
+			if attributes[k] != want {
+				t.Errorf("status %d: attributes[%q] = %#v, want %#v", tt.status, k, attributes[k], want)
+			}
+		}
+		if _, ok := attributes["http.server.request.duration"].(float64); !ok {
+			t.Errorf("status %d: http.server.request.duration = %#v, want seconds", tt.status, attributes["http.server.request.duration"])
+		}
+	}
+}
+
+func TestLokiSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		routed bool
+		status int
+		labels map[string]interface{}
+	}{
+		{"routed", true, http.StatusOK, map[string]interface{}{"method": "GET", "statusClass": "2xx", "route": "/items/{id}"}},
+		{"routed failure", true, http.StatusServiceUnavailable, map[string]interface{}{"method": "GET", "statusClass": "5xx", "route": "/items/{id}"}},
+		{"unmatched", false, http.StatusNotFound, map[string]interface{}{"method": "GET", "statusClass": "4xx", "route": "unmatched"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newObservedLogger(zapcore.DebugLevel)
+			logging := ZapRequestLogger(logger, WithSchema(LokiSchema()), WithMaskQueryParams("token"))
+			h := logging(writeBody(tt.status, "body"))
+			if tt.routed {
+				router := chi.NewRouter()
+				router.Use(logging)
+				router.Get("/items/{id}", writeBody(tt.status, "body"))
+				h = router
+			}
+			h = middleware.RequestID(h)
+			r := httptest.NewRequest(http.MethodGet, "/items/1?token=secret", nil)
+			r.Header.Set("X-Request-Id", "req-1")
+			r.Header.Set("User-Agent", "test")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			completed := loggedFields(t, logs, "Request complete")
+			if _, ok := completed["httpResponse"]; ok {
+				t.Error("httpResponse is logged along with the schema")
+			}
+			if labels := object(t, completed, "labels"); !reflect.DeepEqual(labels, tt.labels) {
+				t.Errorf("labels = %v, want %v", labels, tt.labels)
+			}
+			fields := object(t, completed, "fields")
+			if _, ok := fields["elapsed"].(float64); !ok {
+				t.Errorf("fields.elapsed = %#v, want seconds", fields["elapsed"])
+			}
+			delete(fields, "elapsed")
+			want := map[string]interface{}{
+				"path":       "/items/1",
+				"requestURI": "/items/1?token=***",
+				"requestID":  "req-1",
+				"remoteAddr": "192.0.2.1:1234",
+				"userAgent":  "test",
+				"status":     tt.status,
+				"bytes":      4,
+			}
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("fields = %v, want %v", fields, want)
+			}
+		})
+	}
+}
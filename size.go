@@ -0,0 +1,36 @@
+package httplog
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// requestBytesTotal estimates the bytes of the request on the wire as HTTP/1.1:
+// the request line, the Host and other header lines, the blank line and Content-Length.
+// Transfer encodings, HTTP/2 header compression and unknown body lengths are not accounted for.
+func requestBytesTotal(r *http.Request) int64 {
+	n := int64(len(r.Method) + 1 + len(r.RequestURI) + 1 + len(r.Proto) + 2)
+	n += int64(len("Host: ") + len(r.Host) + 2)
+	n += headerBytes(r.Header) + 2
+	if r.ContentLength > 0 {
+		n += r.ContentLength
+	}
+	return n
+}
+
+// responseBytesTotal estimates the bytes of the response on the wire as HTTP/1.1 in the same way as requestBytesTotal.
+func responseBytesTotal(proto string, status int, header http.Header, bytes int) int64 {
+	n := int64(len(proto) + 1 + len(strconv.Itoa(status)) + 1 + len(http.StatusText(status)) + 2)
+	n += headerBytes(header) + 2
+	return n + int64(bytes)
+}
+
+func headerBytes(header http.Header) int64 {
+	var n int64
+	for k, vs := range header {
+		for _, v := range vs {
+			n += int64(len(k) + 2 + len(v) + 2)
+		}
+	}
+	return n
+}
@@ -0,0 +1,36 @@
+package httplog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type parentSpanIDCtxKey struct{}
+
+// ContextWithParentSpanID returns a copy of ctx carrying the span ID of the caller, e.g. taken from an
+// incoming tracing header by a preceding middleware. It is logged as the parent span with WithSpanID.
+func ContextWithParentSpanID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, parentSpanIDCtxKey{}, id)
+}
+
+// SpanID returns the span ID generated for the request, or an empty string when WithSpanID is disabled.
+func SpanID(ctx context.Context) string {
+	if entry, ok := entryFromContext(ctx); ok {
+		return entry.spanID
+	}
+	return ""
+}
+
+func parentSpanID(ctx context.Context) string {
+	id, _ := ctx.Value(parentSpanIDCtxKey{}).(string)
+	return id
+}
+
+func newID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,50 @@
+package httplog
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// defaultStreamingTypes are the content types treated as streams when WithStreaming lists none.
+var defaultStreamingTypes = []string{"text/event-stream", "application/x-ndjson"}
+
+// streamTee is the Tee of the response writer with WithStreaming. It decides on the first write,
+// when the Content-Type is known, whether the response is a stream. Streams are counted instead of
+// buffered, and their progress is reported every interval; other responses go to body.
+type streamTee struct {
+	body     io.Writer
+	header   http.Header
+	types    map[string]struct{}
+	interval time.Duration
+	progress func(bytes int64)
+
+	decided   bool
+	streaming bool
+	bytes     int64
+	last      time.Time
+}
+
+func (t *streamTee) Write(p []byte) (int, error) {
+	if !t.decided {
+		t.decided = true
+		mediaType, _, _ := mime.ParseMediaType(t.header.Get("Content-Type"))
+		_, t.streaming = t.types[mediaType]
+		t.last = time.Now()
+	}
+	if !t.streaming {
+		if t.body != nil {
+			t.body.Write(p)
+		}
+		return len(p), nil
+	}
+	t.bytes += int64(len(p))
+	if t.interval > 0 {
+		if now := time.Now(); now.Sub(t.last) >= t.interval {
+			t.last = now
+			t.progress(t.bytes)
+		}
+	}
+	return len(p), nil
+}